@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrBudgetExceeded is returned when a script runs out of its steps,
+	// allocations or time.
+	ErrBudgetExceeded = errors.New("budget exceeded")
+	// ErrOutOfMemory is returned when the values a script can still reach
+	// hold more than Options.MaxMemory.
+	ErrOutOfMemory = errors.New("out of memory")
+	// ErrCanceled is returned when the context of a script is done before
+	// the script is.
+	ErrCanceled = errors.New("execution canceled")
+)
+
+// Options limits the resources a script run by ExecuteContext may use, a
+// zero field means no limit.
+type Options struct {
+	// MaxSteps is the number of statements and expressions run
+	MaxSteps int64
+	// Timeout is the wall-clock time the script may run for
+	Timeout time.Duration
+	// MaxAllocations is the number of strings, instances, functions, lists
+	// and big numbers created
+	MaxAllocations int64
+	// MaxMemory is the number of bytes the values the script can still reach
+	// may hold, what it dropped doesn't count
+	MaxMemory int64
+	// KeepTasks lets the tasks the script spawned run on once it returned,
+	// within the same limits, until ctx is done or the interpreter closed
+	KeepTasks bool
+}
+
+// RuntimeError is a runtime error raised by the script.
+type RuntimeError struct {
+	// the value the interpreter panicked with
+	Value any
+}
+
+func (e *RuntimeError) Error() string {
+	return strings.TrimSuffix(fmt.Sprint(e.Value), "\n")
+}
+
+// ExitError is returned when the script ended the run by calling exit, the
+// host decides what to do with the code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// checkInterval is how many steps are run between two checks of the context,
+// which are much slower than counting.
+const checkInterval = 1024
+
+// budget counts the steps and allocations of a run, it's shared by the
+// tasks and generators the run forks so the counters are atomic.
+type budget struct {
+	// the context of the caller and the one of the run, which adds the
+	// timeout and is canceled when the run ends
+	parent context.Context
+	ctx    context.Context
+	opts   Options
+	steps  int64
+	allocs int64
+	bytes  int64
+	// the memory in use when it was last measured and the bytes allocated
+	// since, mu serializes the measures and guards tasks
+	mu      sync.Mutex
+	live    int64
+	pending int64
+	// the interpreters running alongside each other, tracked under
+	// Options.MaxMemory only
+	tasks map[*roots]bool
+}
+
+func newBudget(parent context.Context, opts Options) (*budget, context.CancelFunc) {
+	if opts.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(parent, opts.Timeout)
+		return &budget{parent: parent, ctx: ctx, opts: opts}, cancel
+	}
+	ctx, cancel := context.WithCancel(parent)
+	return &budget{parent: parent, ctx: ctx, opts: opts}, cancel
+}
+
+// step counts a step and aborts the run when it's out of steps or its
+// context is done, it reports whether the context was checked.
+func (b *budget) step() bool {
+	steps := atomic.AddInt64(&b.steps, 1)
+	if b.opts.MaxSteps > 0 && steps > b.opts.MaxSteps {
+		panic(fmt.Errorf("%w: more than %d steps", ErrBudgetExceeded, b.opts.MaxSteps))
+	}
+	if steps%checkInterval == 0 {
+		b.check()
+		return true
+	}
+	return false
+}
+
+func (b *budget) check() {
+	err := b.ctx.Err()
+	if err == nil {
+		return
+	}
+	if perr := b.parent.Err(); perr != nil {
+		panic(fmt.Errorf("%w: %v", ErrCanceled, perr))
+	}
+	if err == context.DeadlineExceeded {
+		panic(fmt.Errorf("%w: ran longer than %v", ErrBudgetExceeded, b.opts.Timeout))
+	}
+	// a task or a generator still running once the run returned
+	panic(fmt.Errorf("%w: the run has ended", ErrCanceled))
+}
+
+// step is called for every statement and expression, it does nothing
+// outside of ExecuteContext. The tasks of the VM take turns between the
+// checks of the context.
+func (i *Interpreter) step() {
+	if i.budget != nil && i.budget.step() && i.gil != nil {
+		i.gil.Unlock()
+		runtime.Gosched()
+		i.gil.Lock()
+	}
+}
+
+// release lets the other tasks of the VM run while the caller blocks, the
+// function returned resumes the caller.
+func (i *Interpreter) release() func() {
+	if i.gil == nil {
+		return func() {}
+	}
+	i.gil.Unlock()
+	return i.gil.Lock
+}
+
+// done returns the channel closed when the run has to stop, the builtins
+// which block select on it. It's nil outside of a run, which never closes.
+func (i *Interpreter) done() <-chan struct{} {
+	if i.budget == nil {
+		return nil
+	}
+	return i.budget.ctx.Done()
+}
+
+// abort raises the error stopping the run, once done is closed.
+func (i *Interpreter) abort() {
+	i.budget.check()
+}
+
+// ExecuteContext runs the statements like Execute, within the limits of opts
+// and until ctx is done. Instead of being printed, errors are returned: they
+// wrap ErrBudgetExceeded, ErrOutOfMemory or ErrCanceled when the run is
+// aborted and are a *RuntimeError when the script fails. The tasks the run
+// spawned are canceled when it returns, unless opts.KeepTasks is set.
+func (i *Interpreter) ExecuteContext(ctx context.Context, stmts []Stmt, opts Options) (any, error) {
+	return i.runWithin(ctx, opts, func() (ret any) {
+		for _, stmt := range stmts {
+			ret = i.execute(stmt)
+		}
+		return ret
+	}, func() {
+		i.env = i.globals
+		i.frames = nil
+	})
+}
+
+// runWithin runs fn within the limits of opts, reset restores the state a
+// runtime error left behind.
+func (i *Interpreter) runWithin(ctx context.Context, opts Options, fn func() any, reset func()) (ret any, err error) {
+	b, cancel := newBudget(ctx, opts)
+	if opts.KeepTasks {
+		go func() {
+			select {
+			case <-i.closed:
+			case <-b.ctx.Done():
+			}
+			cancel()
+		}()
+	} else {
+		defer cancel()
+	}
+	i.budget = b
+	defer func() {
+		i.budget = nil
+		i.roots = nil
+		i.memoryStats = b.memoryStats()
+		if r := recover(); r != nil {
+			ret = nil
+			reset()
+			err = asError(r)
+		}
+	}()
+	// a context which is already done doesn't run anything
+	b.check()
+	return fn(), nil
+}
+
+func asError(r any) error {
+	if exit, ok := r.(*ExitError); ok {
+		return exit
+	}
+	if err, ok := r.(error); ok && (errors.Is(err, ErrBudgetExceeded) || errors.Is(err, ErrOutOfMemory) || errors.Is(err, ErrCanceled)) {
+		return err
+	}
+	return &RuntimeError{Value: r}
+}
+
+// printError prints an error of ExecuteContext the way Execute always has.
+func printError(err error) {
+	if re, ok := err.(*RuntimeError); ok {
+		fmt.Println(re.Value)
+		return
+	}
+	fmt.Println(err)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parse parses and resolves source for inter.
+func parse(t *testing.T, inter *Interpreter, source string) []Stmt {
+	t.Helper()
+	hadError = false
+	parser := &Parser{tokens: NewScanner(source).ScanTokens()}
+	stmts := parser.ParseStmts()
+	NewResolver(inter).Resolve(stmts)
+	if hadError {
+		t.Fatalf("can't parse %q", source)
+	}
+	return stmts
+}
+
+// execute runs source in a fresh interpreter, with the VM when useVM is set.
+func execute(t *testing.T, source string, useVM bool, opts Options) (any, error) {
+	t.Helper()
+	return executeContext(t, context.Background(), source, useVM, opts)
+}
+
+func executeContext(t *testing.T, ctx context.Context, source string, useVM bool, opts Options) (any, error) {
+	t.Helper()
+	inter := NewInterpreter()
+	t.Cleanup(inter.Close)
+	return executeIn(t, ctx, inter, source, useVM, opts)
+}
+
+// executeIn runs source in inter, with a new VM when useVM is set.
+func executeIn(t *testing.T, ctx context.Context, inter *Interpreter, source string, useVM bool, opts Options) (any, error) {
+	t.Helper()
+	stmts := parse(t, inter, source)
+	var ret any
+	var err error
+	captureOutput(t, func() {
+		if useVM {
+			ret, err = NewVM(inter).InterpretContext(ctx, Compile(stmts, nil, false), opts)
+			return
+		}
+		ret, err = inter.ExecuteContext(ctx, stmts, opts)
+	})
+	return ret, err
+}
+
+func TestTimeout(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		start := time.Now()
+		_, err := execute(t, "while (true) {}", useVM, Options{Timeout: 50 * time.Millisecond})
+		assert.ErrorIs(t, err, ErrBudgetExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		_, err := execute(t, "var n = 0; while (true) { n = n + 1; }", useVM, Options{MaxSteps: 1000})
+		assert.ErrorIs(t, err, ErrBudgetExceeded)
+		ret, err := execute(t, "var n = 0; while (n < 10) { n = n + 1; }", useVM, Options{MaxSteps: 1000})
+		assert.NoError(t, err, ret)
+	}
+}
+
+// TestGeneratorsWithinBudget checks the body of a generator takes its steps
+// from the run resuming it.
+func TestGeneratorsWithinBudget(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		_, err := execute(t, "fun g() { while (true) {} yield 1; }\ng().next();", useVM, Options{MaxSteps: 1000})
+		assert.ErrorIs(t, err, ErrBudgetExceeded, "vm: %v", useVM)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := executeContext(t, ctx, "print 1;", false, Options{})
+	assert.ErrorIs(t, err, ErrCanceled)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = executeContext(t, ctx, "while (true) {}", false, Options{})
+	assert.ErrorIs(t, err, ErrCanceled, "the deadline of the caller is a cancellation")
+	assert.False(t, errors.Is(err, ErrBudgetExceeded))
+}
+
+func TestRuntimeError(t *testing.T) {
+	_, err := execute(t, "1 + nil;", false, Options{})
+	var re *RuntimeError
+	assert.True(t, errors.As(err, &re))
+	assert.Contains(t, err.Error(), "should be float type")
+}
+
+func TestBlockingBuiltinsStop(t *testing.T) {
+	for _, source := range []string{
+		"sleep(30);",
+		"Channel(0).recv();",
+		"Channel(0).send(1);",
+		"fun f() { Channel(0).recv(); } spawn f().join();",
+	} {
+		start := time.Now()
+		_, err := execute(t, source, false, Options{Timeout: 50 * time.Millisecond})
+		assert.ErrorIs(t, err, ErrBudgetExceeded, source)
+		assert.Less(t, time.Since(start), time.Second, source)
+	}
+}
+
+func TestTasksCanceledWhenRunEnds(t *testing.T) {
+	_, err := execute(t, "fun spin() { while (true) {} } spawn spin();", false, Options{})
+	assert.NoError(t, err)
+	assertTasksStop(t)
+}
+
+// TestKeepTasks checks a run can join the task an earlier one spawned, the
+// way the REPL runs its lines, and that closing the interpreter cancels it.
+func TestKeepTasks(t *testing.T) {
+	inter := NewInterpreter()
+	opts := Options{KeepTasks: true}
+	ctx := context.Background()
+	_, err := executeIn(t, ctx, inter, "fun work() { var s = 0; for (var k = 0; k < 10000; k = k + 1) s = s + 1; return s; } var t = spawn work();", false, opts)
+	assert.NoError(t, err)
+	ret, err := executeIn(t, ctx, inter, "t.join();", false, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(10000), ret)
+	_, err = executeIn(t, ctx, inter, "fun spin() { while (true) {} } spawn spin();", false, opts)
+	assert.NoError(t, err)
+	inter.Close()
+	assertTasksStop(t)
+}
+
+// assertTasksStop waits for the goroutines of the tasks to exit.
+func assertTasksStop(t *testing.T) {
+	t.Helper()
+	buf := make([]byte, 1<<20)
+	for k := 0; k < 100; k++ {
+		n := runtime.Stack(buf, true)
+		if !strings.Contains(string(buf[:n]), "NewLoxTask") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the task kept running after the run ended")
+}
@@ -2,31 +2,72 @@ package main
 
 import (
 	"fmt"
-	"time"
 )
 
-type ReturnPanic struct {
-	Value any
+type completionKind int
+
+const (
+	COMPLETION_RETURN completionKind = iota
+	// a 'return f(x);', the function returning runs the call itself
+	COMPLETION_TAIL_CALL
+)
+
+// completion is what a statement ending the ones around it early results
+// in, the others result in nil. Lox has no break, continue or throw, so a
+// return unwinding the blocks up to the call is the only one for now.
+type completion struct {
+	kind  completionKind
+	value any
+	// the call to run for a tail call
+	callee Callable
+	args   []any
 }
 
 type Callable interface {
-	Arity() int
+	// Arity returns the minimum and maximum number of arguments, the maximum
+	// is -1 when any number of trailing arguments is accepted.
+	Arity() (int, int)
 	Call(*Interpreter, []any) any
 	ToString() string
 	Bind(in *LoxInstance) Callable
 }
 
+// keywordCallable is implemented by callables accepting arguments by name.
+type keywordCallable interface {
+	Params() []*Token
+}
+
+// missingArgument fills the positions skipped by keyword arguments, the
+// parameter takes its default value.
+type missingArgument struct{}
+
 type CallableFunc func([]any) any
 
+// InterpreterFunc is a primitive calling back into the interpreter running
+// it, e.g. to invoke methods defined in Lox.
+type InterpreterFunc func(*Interpreter, []any) any
+
 type callableImpl struct {
 	argsNumber    int
+	variadic      bool
 	fn            CallableFunc
+	interFn       InterpreterFunc
 	primitive     bool
 	declaration   *Function
 	closure       *Environment
 	isInitializer bool
+	// the class declaring the method, it owns the private members the
+	// method can access
+	class *LoxClass
 }
 
+// privateOwner is the hidden variable bound next to 'this' holding the class
+// whose private members can be accessed, scripts can't name it.
+const privateOwner = "<class>"
+
+// privateOwnerSlot is the slot of privateOwner, 'this' takes the first one.
+const privateOwnerSlot = 1
+
 func NewPrimitive(arity int, fn CallableFunc) Callable {
 	return Callable(&callableImpl{
 		argsNumber:    arity,
@@ -36,6 +77,33 @@ func NewPrimitive(arity int, fn CallableFunc) Callable {
 	})
 }
 
+// NewVariadicPrimitive returns a primitive accepting at least min arguments.
+func NewVariadicPrimitive(min int, fn CallableFunc) Callable {
+	return Callable(&callableImpl{
+		argsNumber: min,
+		variadic:   true,
+		primitive:  true,
+		fn:         fn,
+	})
+}
+
+func NewInterpreterPrimitive(arity int, fn InterpreterFunc) Callable {
+	return Callable(&callableImpl{
+		argsNumber: arity,
+		primitive:  true,
+		interFn:    fn,
+	})
+}
+
+func NewVariadicInterpreterPrimitive(min int, fn InterpreterFunc) Callable {
+	return Callable(&callableImpl{
+		argsNumber: min,
+		variadic:   true,
+		primitive:  true,
+		interFn:    fn,
+	})
+}
+
 func NewCallable(declaration *Function, e *Environment, isInitializer bool) Callable {
 	return Callable(&callableImpl{
 		primitive:     false,
@@ -45,6 +113,16 @@ func NewCallable(declaration *Function, e *Environment, isInitializer bool) Call
 	})
 }
 
+// NewMethod returns a method declared in class.
+func NewMethod(declaration *Function, e *Environment, isInitializer bool, class *LoxClass) Callable {
+	return Callable(&callableImpl{
+		declaration:   declaration,
+		closure:       e,
+		isInitializer: isInitializer,
+		class:         class,
+	})
+}
+
 func (c *callableImpl) ToString() string {
 	if c.primitive {
 		return "<fn primitive>"
@@ -55,48 +133,161 @@ func (c *callableImpl) ToString() string {
 func (c *callableImpl) Bind(in *LoxInstance) Callable {
 	env := NewEnvironmentWithAncestor(c.closure)
 	env.Define("this", in)
+	if c.class != nil {
+		env.Define(privateOwner, c.class)
+	}
 	return NewCallable(c.declaration, env, c.isInitializer)
 }
 
-func (c *callableImpl) Call(i *Interpreter, args []any) (ret any) {
-	if len(args) != c.Arity() {
-		panic(fmt.Sprintf("Expected %v arguments but got %v", c.argsNumber, len(args)))
+func (c *callableImpl) Call(i *Interpreter, args []any) any {
+	// tail calls replace c and args and loop, so they don't take Go stack
+	for {
+		if msg := arityMessage(c, len(args)); msg != "" {
+			Panic(i.line, msg)
+		}
+		if c.interFn != nil {
+			return c.interFn(i, args)
+		}
+		if c.primitive {
+			return c.fn(args)
+		}
+		e := NewEnvironmentWithAncestor(c.closure)
+		// the defaults may run calls of their own
+		line := i.line
+		params := c.declaration.params
+		for k, v := range params {
+			if k < len(args) {
+				if _, ok := args[k].(missingArgument); !ok {
+					e.Define(v.lexeme, args[k])
+					continue
+				}
+			}
+			if c.declaration.defaults[k] == nil {
+				Panic(line, fmt.Sprintf("Missing argument for parameter '%s'.", v.lexeme))
+			}
+			e.Define(v.lexeme, i.evaluateIn(c.declaration.defaults[k], e))
+		}
+		if c.declaration.rest != nil {
+			rest := []any{}
+			if len(args) > len(params) {
+				rest = append(rest, args[len(params):]...)
+			}
+			i.allocate(listSize + elementSize*len(rest))
+			e.Define(c.declaration.rest.lexeme, NewLoxList(rest))
+		}
+		for k, pattern := range c.declaration.patterns {
+			if pattern != nil {
+				destructure(pattern, e.GetAt(0, k), defineIn(e, false))
+			}
+		}
+		if c.declaration.generator {
+			return NewLoxGenerator(i, c.declaration, e)
+		}
+		result := i.executeBlock(c.declaration.body, e)
+		// an initializer always returns this, even after an early return
+		if c.isInitializer {
+			return c.closure.GetAt(0, 0)
+		}
+		done, ok := result.(*completion)
+		if !ok {
+			return nil
+		}
+		if done.kind != COMPLETION_TAIL_CALL {
+			return done.value
+		}
+		next, ok := done.callee.(*callableImpl)
+		if !ok {
+			return done.callee.Call(i, done.args)
+		}
+		c, args = next, done.args
 	}
+}
+
+func (c *callableImpl) Arity() (int, int) {
 	if c.primitive {
-		return c.fn(args)
-	}
-	e := NewEnvironmentWithAncestor(c.closure)
-	for k, v := range c.declaration.params {
-		e.Define(v.lexeme, args[k])
+		if c.variadic {
+			return c.argsNumber, -1
+		}
+		return c.argsNumber, c.argsNumber
 	}
-	defer func() {
-		if r := recover(); r != nil {
-			switch r.(type) {
-			// implements the return syntax
-			case *ReturnPanic:
-				ret = r.(*ReturnPanic).Value
-			default:
-				panic(r)
-			}
+	// parameters with default values can only follow the required ones
+	min := 0
+	for _, d := range c.declaration.defaults {
+		if d != nil {
+			break
 		}
-	}()
-	i.executeBlock(c.declaration.body, e)
-	if c.isInitializer {
-		return c.closure.GetAt(0, "this")
+		min++
+	}
+	if c.declaration.rest != nil {
+		return min, -1
 	}
-	return nil
+	return min, len(c.declaration.params)
 }
 
-func (c *callableImpl) Arity() int {
+func (c *callableImpl) Params() []*Token {
 	if c.primitive {
-		return c.argsNumber
+		return nil
+	}
+	return c.declaration.params
+}
+
+// arityMessage describes the expected number of arguments when n doesn't fit
+// the arity of fn, an empty string is returned otherwise.
+func arityMessage(fn Callable, n int) string {
+	min, max := fn.Arity()
+	return arityText(min, max, n)
+}
+
+func arityText(min, max, n int) string {
+	switch {
+	case n >= min && (max < 0 || n <= max):
+		return ""
+	case min == max:
+		return fmt.Sprintf("Expected %v arguments but got %v", min, n)
+	case max < 0:
+		return fmt.Sprintf("Expected at least %v arguments but got %v", min, n)
+	default:
+		return fmt.Sprintf("Expected %v to %v arguments but got %v", min, max, n)
 	}
-	return len(c.declaration.params)
 }
 
 func injectPrimitives(i *Interpreter) *Interpreter {
-	i.env.Define("clock", NewPrimitive(0, func(args []any) any {
-		return float64(time.Now().Unix())
+	i.env.Define("Channel", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		capacity, ok := args[0].(float64)
+		if !ok || capacity < 0 || capacity != float64(int(capacity)) {
+			Panic(i.line, fmt.Sprintf("Channel capacity must be a non-negative integer but got %v", ToString(args[0])))
+		}
+		return NewLoxChannel(int(capacity))
+	}))
+	i.env.Define("List", NewVariadicInterpreterPrimitive(0, func(i *Interpreter, args []any) any {
+		i.allocate(listSize + elementSize*len(args))
+		return NewLoxList(append([]any{}, args...))
+	}))
+	i.env.Define("Decimal", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		if s, ok := args[0].(string); ok {
+			d, err := ParseDecimal(s)
+			if err != nil {
+				Panic(i.line, fmt.Sprintf("Invalid decimal %v", Repr(s)))
+			}
+			return i.allocateNumber(d)
+		}
+		if !isNumber(args[0]) {
+			Panic(i.line, fmt.Sprintf("Decimal expects a number or a string but got %v", ToString(args[0])))
+		}
+		return i.allocateNumber(toDecimal(args[0]))
+	}))
+	i.env.Define("str", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		return i.stringify(args[0])
+	}))
+	i.env.Define("freeze", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		in, ok := args[0].(instance)
+		if !ok {
+			Panic(i.line, fmt.Sprintf("Only instances can be frozen but got %v", ToString(args[0])))
+		}
+		in.Freeze()
+		return in
 	}))
+	injectReflection(i)
+	injectStdlib(i)
 	return i
 }
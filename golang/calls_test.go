@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStackOverflow(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		inter := NewInterpreter()
+		t.Cleanup(inter.Close)
+		inter.SetMaxCallDepth(100)
+		_, err := executeIn(t, context.Background(), inter, "fun f(n) { return 1 + f(n + 1); }\nf(0);", useVM, Options{})
+		var re *RuntimeError
+		assert.True(t, errors.As(err, &re), "vm: %v", useVM)
+		assert.Contains(t, err.Error(), "Stack overflow\n    in <fn f> called at line 1 (99 times)\n    in <fn f> called at line 2", "vm: %v", useVM)
+		// the interpreter is still usable afterwards
+		_, err = executeIn(t, context.Background(), inter, "fun g(n) { if (n == 0) return 0; return 1 + g(n - 1); }\ng(90);", useVM, Options{})
+		assert.NoError(t, err, "vm: %v", useVM)
+	}
+}
+
+// TestDeepRecursionIsAnError checks the default depth is reached before the
+// Go stack runs out.
+func TestDeepRecursionIsAnError(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		_, err := execute(t, "class A { f(n) { return this.g(n) + 1; } g(n) { return this.f(n) + 1; } }\nA().f(0);", useVM, Options{})
+		assert.ErrorContains(t, err, "Stack overflow", "vm: %v", useVM)
+	}
+}
+
+func TestTailCallsDontCount(t *testing.T) {
+	source := `fun loop(n, acc) { if (n == 0) return acc; return loop(n - 1, acc + 1); }
+// an undefined variable fails the run when the result is wrong
+if (loop(100000, 0) != 100000) nope;`
+	for _, useVM := range []bool{false, true} {
+		inter := NewInterpreter()
+		t.Cleanup(inter.Close)
+		inter.SetMaxCallDepth(10)
+		_, err := executeIn(t, context.Background(), inter, source, useVM, Options{})
+		assert.NoError(t, err, "vm: %v", useVM)
+	}
+}
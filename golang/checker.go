@@ -0,0 +1,1077 @@
+package main
+
+import "fmt"
+
+type TypeKind int
+
+const (
+	TYPEANY TypeKind = iota
+	TYPENUMBER
+	TYPESTRING
+	TYPEBOOL
+	TYPENIL
+	TYPELIST
+	TYPEFUNCTION
+	TYPECLASS
+	TYPEINSTANCE
+)
+
+// LoxType is the static type the checker gives to an expression. Function
+// types carry the signature when it is known, class and instance types carry
+// the class.
+type LoxType struct {
+	kind  TypeKind
+	fn    *funcType
+	class *classType
+}
+
+var (
+	anyType      = &LoxType{kind: TYPEANY}
+	numberType   = &LoxType{kind: TYPENUMBER}
+	stringType   = &LoxType{kind: TYPESTRING}
+	boolType     = &LoxType{kind: TYPEBOOL}
+	nilType      = &LoxType{kind: TYPENIL}
+	listType     = &LoxType{kind: TYPELIST}
+	functionType = &LoxType{kind: TYPEFUNCTION}
+)
+
+// typeNames are the builtin names an annotation can use, classes are the
+// other ones.
+var typeNames = map[string]*LoxType{
+	"Any":      anyType,
+	"Number":   numberType,
+	"String":   stringType,
+	"Bool":     boolType,
+	"Nil":      nilType,
+	"List":     listType,
+	"Function": functionType,
+}
+
+func (t *LoxType) String() string {
+	switch t.kind {
+	case TYPENUMBER:
+		return "Number"
+	case TYPESTRING:
+		return "String"
+	case TYPEBOOL:
+		return "Bool"
+	case TYPENIL:
+		return "Nil"
+	case TYPELIST:
+		return "List"
+	case TYPEFUNCTION:
+		return "Function"
+	case TYPECLASS:
+		return "class " + t.class.name
+	case TYPEINSTANCE:
+		return t.class.name
+	}
+	return "Any"
+}
+
+type funcType struct {
+	name       string
+	params     []*Token
+	paramTypes []*LoxType
+	// the type of the arguments a rest parameter collects
+	restType *LoxType
+	min, max int
+	returns  *LoxType
+}
+
+type classType struct {
+	name       string
+	superclass *classType
+	traits     []*classType
+	methods    map[string]*funcType
+	// field types, declared in the class body or inferred from assignments
+	fields map[string]*LoxType
+	// fields declared in the class body, assigning other fields is an error
+	// once a class declares any
+	declared map[string]bool
+	// the class inherits from something the checker can't follow, so its
+	// properties are not checked
+	dynamic bool
+	trait   bool
+	enum    bool
+}
+
+func (ct *classType) instance() *LoxType {
+	if ct.trait || ct.enum {
+		return anyType
+	}
+	return &LoxType{kind: TYPEINSTANCE, class: ct}
+}
+
+func (ct *classType) findMethod(name string) *funcType {
+	if fn, ok := ct.methods[name]; ok {
+		return fn
+	}
+	for _, trait := range ct.traits {
+		if fn := trait.findMethod(name); fn != nil {
+			return fn
+		}
+	}
+	if ct.superclass != nil {
+		return ct.superclass.findMethod(name)
+	}
+	return nil
+}
+
+// findField returns the type of the field and the class holding it.
+func (ct *classType) findField(name string) (*LoxType, *classType) {
+	for c := ct; c != nil; c = c.superclass {
+		if typ, ok := c.fields[name]; ok {
+			return typ, c
+		}
+	}
+	return nil, nil
+}
+
+func (ct *classType) isDynamic() bool {
+	for c := ct; c != nil; c = c.superclass {
+		if c.dynamic {
+			return true
+		}
+		for _, trait := range c.traits {
+			if trait.isDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ct *classType) sealed() bool {
+	for c := ct; c != nil; c = c.superclass {
+		if len(c.declared) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (ct *classType) isSubclassOf(other *classType) bool {
+	for c := ct; c != nil; c = c.superclass {
+		if c == other {
+			return true
+		}
+	}
+	return ct.isDynamic()
+}
+
+// listMethods are the signatures of the methods of LoxList.
+var listMethods = map[string]*funcType{
+	"length": {name: "length", returns: numberType},
+	"get":    {name: "get", min: 1, max: 1, paramTypes: []*LoxType{numberType}, returns: anyType},
+	"set":    {name: "set", min: 2, max: 2, paramTypes: []*LoxType{numberType, anyType}, returns: anyType},
+	"push":   {name: "push", min: 1, max: 1, paramTypes: []*LoxType{anyType}, returns: nilType},
+	"pop":    {name: "pop", returns: anyType},
+}
+
+// builtinReturns are the result types of the primitives not returning Any.
+var builtinReturns = map[string]*LoxType{
+	"clock":      numberType,
+	"List":       listType,
+	"str":        stringType,
+	"type":       stringType,
+	"fields":     listType,
+	"methods":    listType,
+	"hasField":   boolType,
+	"readFile":   stringType,
+	"fileExists": boolType,
+	"listDir":    listType,
+	"args":       listType,
+}
+
+type binding struct {
+	typ *LoxType
+	// the type was declared, assigned values must conform to it
+	annotated bool
+	decl      *Token
+}
+
+// TypeChecker infers the types of a resolved program and reports the
+// mismatches with the annotations, the unknown properties and the arity
+// errors it finds. Annotations are never looked at while running.
+type TypeChecker struct {
+	builtins map[string]*binding
+	scopes   []map[string]*binding
+	// classes by the token declaring them, kept across passes so fields
+	// assigned anywhere are known everywhere
+	classes map[*Token]*classType
+	byName  map[string]*classType
+	// unannotated variables assigned values of different types
+	widened      map[*Token]bool
+	currentFunc  *funcType
+	returns      *LoxType
+	currentClass *classType
+	this         *LoxType
+	silent       bool
+	errors       []string
+}
+
+func NewTypeChecker(inter *Interpreter) *TypeChecker {
+	c := &TypeChecker{
+		builtins: make(map[string]*binding),
+		classes:  make(map[*Token]*classType),
+		byName:   make(map[string]*classType),
+		widened:  make(map[*Token]bool),
+	}
+	inter.globals.mu.RLock()
+	defer inter.globals.mu.RUnlock()
+	for name, value := range inter.globals.envs {
+		typ := anyType
+		if fn, ok := value.(Callable); ok {
+			typ = &LoxType{kind: TYPEFUNCTION, fn: builtinType(name, fn)}
+		}
+		c.builtins[name] = &binding{typ: typ, annotated: true}
+	}
+	return c
+}
+
+func builtinType(name string, fn Callable) *funcType {
+	min, max := fn.Arity()
+	ft := &funcType{name: name, min: min, max: max, returns: anyType}
+	if kc, ok := fn.(keywordCallable); ok {
+		ft.params = kc.Params()
+	}
+	if ret, ok := builtinReturns[name]; ok {
+		ft.returns = ret
+	}
+	return ft
+}
+
+// Check returns the type errors of the program. The first pass only learns
+// the fields of the classes and the variables assigned values of different
+// types, the second one reports.
+func (c *TypeChecker) Check(stmts []Stmt) []string {
+	for _, silent := range []bool{true, false} {
+		c.silent = silent
+		c.errors = nil
+		c.scopes = []map[string]*binding{make(map[string]*binding)}
+		for name, b := range c.builtins {
+			c.scopes[0][name] = b
+		}
+		c.checkStmts(stmts)
+	}
+	return c.errors
+}
+
+func (c *TypeChecker) error(line int, msg string) {
+	if !c.silent {
+		c.errors = append(c.errors, fmt.Sprintf("[line %v] type error: %v", line, msg))
+	}
+}
+
+func (c *TypeChecker) checkStmts(stmts []Stmt) {
+	for _, stmt := range stmts {
+		stmt.Accept(c)
+	}
+}
+
+func (c *TypeChecker) checkExpr(expr Expr) *LoxType {
+	return expr.Accept(c).(*LoxType)
+}
+
+func (c *TypeChecker) beginScope() {
+	c.scopes = append(c.scopes, make(map[string]*binding))
+}
+
+func (c *TypeChecker) endScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *TypeChecker) declare(name *Token, typ *LoxType, annotated bool) {
+	// an unannotated variable keeps the type of its initializer unless it is
+	// assigned something else somewhere
+	if !annotated && (c.widened[name] || typ.kind == TYPENIL) {
+		typ = anyType
+	}
+	c.scopes[len(c.scopes)-1][name.lexeme] = &binding{typ: typ, annotated: annotated, decl: name}
+}
+
+func (c *TypeChecker) lookup(name string) *binding {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if b, ok := c.scopes[i][name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// resolveType returns the type an annotation names, a class name stands for
+// its instances.
+func (c *TypeChecker) resolveType(name *Token) *LoxType {
+	if typ, ok := typeNames[name.lexeme]; ok {
+		return typ
+	}
+	if b := c.lookup(name.lexeme); b != nil && b.typ.kind == TYPECLASS {
+		return b.typ.class.instance()
+	}
+	// classes declared further down
+	if ct, ok := c.byName[name.lexeme]; ok {
+		return ct.instance()
+	}
+	c.error(name.line, fmt.Sprintf("Unknown type '%s'.", name.lexeme))
+	return anyType
+}
+
+func assignable(want, got *LoxType) bool {
+	if want.kind == TYPEANY || got.kind == TYPEANY {
+		return true
+	}
+	switch want.kind {
+	case TYPEINSTANCE:
+		if got.kind == TYPENIL {
+			return true
+		}
+		return got.kind == TYPEINSTANCE && got.class.isSubclassOf(want.class)
+	case TYPEFUNCTION:
+		// classes are called like functions
+		return got.kind == TYPEFUNCTION || got.kind == TYPECLASS
+	}
+	return want.kind == got.kind
+}
+
+func sameType(a, b *LoxType) bool {
+	return a.kind == b.kind && a.class == b.class && a.fn == b.fn
+}
+
+func (c *TypeChecker) expect(line int, want, got *LoxType, what string) {
+	if !assignable(want, got) {
+		c.error(line, fmt.Sprintf("Type mismatch for %s: expected %s but got %s.", what, want, got))
+	}
+}
+
+func (c *TypeChecker) VisitExpressionStmt(stmt Stmt) any {
+	s, ok := stmt.(*Expression)
+	if !ok {
+		panic("should be expression type stmt")
+	}
+	c.checkExpr(s.expr)
+	return nil
+}
+
+func (c *TypeChecker) VisitPrintStmt(stmt Stmt) any {
+	s, ok := stmt.(*Print)
+	if !ok {
+		panic("should be print type stmt")
+	}
+	c.checkExpr(s.expr)
+	return nil
+}
+
+func (c *TypeChecker) VisitVarStmt(stmt Stmt) any {
+	s, ok := stmt.(*Var)
+	if !ok {
+		panic("should be variable type stmt")
+	}
+	typ := nilType
+	if s.initializer != nil {
+		typ = c.checkExpr(s.initializer)
+	}
+	if s.annotation == nil {
+		c.declare(s.name, typ, false)
+		return nil
+	}
+	want := c.resolveType(s.annotation)
+	c.expect(s.name.line, want, typ, fmt.Sprintf("variable '%s'", s.name.lexeme))
+	c.declare(s.name, want, true)
+	return nil
+}
+
+func (c *TypeChecker) VisitDestructureStmt(stmt Stmt) any {
+	s, ok := stmt.(*Destructure)
+	if !ok {
+		panic("should be destructure type stmt")
+	}
+	c.checkExpr(s.initializer)
+	c.checkPattern(s.pattern)
+	return nil
+}
+
+func (c *TypeChecker) VisitBlockStmt(stmt Stmt) any {
+	s, ok := stmt.(*Block)
+	if !ok {
+		panic("should be block type stmt")
+	}
+	c.beginScope()
+	c.checkStmts(s.statements)
+	c.endScope()
+	return nil
+}
+
+func (c *TypeChecker) VisitIfStmt(stmt Stmt) any {
+	s, ok := stmt.(*If)
+	if !ok {
+		panic("should be if type stmt")
+	}
+	c.checkExpr(s.condition)
+	s.thenBranch.Accept(c)
+	if s.elseBranch != nil {
+		s.elseBranch.Accept(c)
+	}
+	return nil
+}
+
+func (c *TypeChecker) VisitWhileStmt(stmt Stmt) any {
+	s, ok := stmt.(*While)
+	if !ok {
+		panic("should be while type stmt")
+	}
+	c.checkExpr(s.condition)
+	s.body.Accept(c)
+	return nil
+}
+
+func (c *TypeChecker) VisitReturnStmt(stmt Stmt) any {
+	s, ok := stmt.(*Return)
+	if !ok {
+		panic("should be return type stmt")
+	}
+	typ := nilType
+	if s.value != nil {
+		typ = c.checkExpr(s.value)
+	}
+	if c.currentFunc != nil {
+		c.expect(s.keyword.line, c.returns, typ, fmt.Sprintf("return value of '%s'", c.currentFunc.name))
+	}
+	return nil
+}
+
+func (c *TypeChecker) VisitYieldStmt(stmt Stmt) any {
+	s, ok := stmt.(*Yield)
+	if !ok {
+		panic("should be yield type stmt")
+	}
+	if s.value != nil {
+		c.checkExpr(s.value)
+	}
+	return nil
+}
+
+func (c *TypeChecker) VisitEnumStmt(stmt Stmt) any {
+	s, ok := stmt.(*Enum)
+	if !ok {
+		panic("should be enum type stmt")
+	}
+	ct := c.classFor(s.name)
+	ct.enum = true
+	ct.dynamic = true
+	c.declare(s.name, &LoxType{kind: TYPECLASS, class: ct}, true)
+	return nil
+}
+
+func (c *TypeChecker) VisitFunctionStmt(stmt Stmt) any {
+	s, ok := stmt.(*Function)
+	if !ok {
+		panic("should be function type stmt")
+	}
+	fn := c.functionType(s)
+	// declared first so the body can recurse
+	c.declare(s.name, &LoxType{kind: TYPEFUNCTION, fn: fn}, true)
+	c.checkFunction(s, fn, fn.returns)
+	return nil
+}
+
+// functionType returns the signature of a declaration, unannotated
+// parameters and results are Any.
+func (c *TypeChecker) functionType(s *Function) *funcType {
+	fn := &funcType{
+		name:    s.name.lexeme,
+		params:  s.params,
+		max:     len(s.params),
+		returns: anyType,
+	}
+	for k := range s.params {
+		typ := anyType
+		if s.paramTypes[k] != nil {
+			typ = c.resolveType(s.paramTypes[k])
+		}
+		fn.paramTypes = append(fn.paramTypes, typ)
+	}
+	for _, d := range s.defaults {
+		if d != nil {
+			break
+		}
+		fn.min++
+	}
+	if s.rest != nil {
+		fn.max = -1
+		fn.restType = anyType
+		if s.restType != nil {
+			fn.restType = c.resolveType(s.restType)
+		}
+	}
+	if s.returnType != nil {
+		fn.returns = c.resolveType(s.returnType)
+	}
+	// calling a generator returns the generator
+	if s.generator {
+		fn.returns = anyType
+	}
+	return fn
+}
+
+// checkFunction checks the body of a function, returns is the type its return
+// statements must conform to.
+func (c *TypeChecker) checkFunction(s *Function, fn *funcType, returns *LoxType) {
+	enclosingFunc, enclosingReturns := c.currentFunc, c.returns
+	c.currentFunc, c.returns = fn, returns
+	defer func() {
+		c.currentFunc, c.returns = enclosingFunc, enclosingReturns
+	}()
+
+	c.beginScope()
+	for k, param := range s.params {
+		if s.defaults[k] != nil {
+			c.expect(param.line, fn.paramTypes[k], c.checkExpr(s.defaults[k]),
+				fmt.Sprintf("default value of parameter '%s'", param.lexeme))
+		}
+		c.declare(param, fn.paramTypes[k], true)
+	}
+	if s.rest != nil {
+		c.declare(s.rest, listType, true)
+	}
+	for _, pattern := range s.patterns {
+		if pattern != nil {
+			c.checkPattern(pattern)
+		}
+	}
+	c.checkStmts(s.body)
+	c.endScope()
+}
+
+// classFor returns the class declared by name, the inferred fields survive
+// from the previous pass.
+func (c *TypeChecker) classFor(name *Token) *classType {
+	ct, ok := c.classes[name]
+	if !ok {
+		ct = &classType{
+			name:   name.lexeme,
+			fields: make(map[string]*LoxType),
+		}
+		c.classes[name] = ct
+	}
+	ct.superclass = nil
+	ct.traits = nil
+	ct.dynamic = false
+	ct.methods = make(map[string]*funcType)
+	ct.declared = make(map[string]bool)
+	c.byName[name.lexeme] = ct
+	return ct
+}
+
+// classTraits resolves the traits included by a class or a trait.
+func (c *TypeChecker) classTraits(ct *classType, traits []*Variable) {
+	for _, v := range traits {
+		typ := c.checkExpr(v)
+		switch {
+		case typ.kind == TYPECLASS && typ.class.trait:
+			ct.traits = append(ct.traits, typ.class)
+		case typ.kind == TYPEANY:
+			ct.dynamic = true
+		default:
+			c.error(v.name.line, fmt.Sprintf("'%s' is not a trait.", v.name.lexeme))
+		}
+	}
+}
+
+func (c *TypeChecker) VisitTraitStmt(stmt Stmt) any {
+	s, ok := stmt.(*Trait)
+	if !ok {
+		panic("should be trait type stmt")
+	}
+	ct := c.classFor(s.name)
+	ct.trait = true
+	c.classTraits(ct, s.traits)
+	c.declare(s.name, &LoxType{kind: TYPECLASS, class: ct}, true)
+
+	fns := make([]*funcType, len(s.methods))
+	for k, method := range s.methods {
+		fns[k] = c.functionType(method)
+		ct.methods[method.name.lexeme] = fns[k]
+	}
+	// a trait doesn't know the classes it ends up in
+	enclosingClass, enclosingThis := c.currentClass, c.this
+	c.currentClass, c.this = nil, anyType
+	for k, method := range s.methods {
+		c.checkFunction(method, fns[k], fns[k].returns)
+	}
+	c.currentClass, c.this = enclosingClass, enclosingThis
+	return nil
+}
+
+func (c *TypeChecker) VisitClassStmt(stmt Stmt) any {
+	s, ok := stmt.(*Class)
+	if !ok {
+		panic("should be class type stmt")
+	}
+	ct := c.classFor(s.name)
+	if s.superclass != nil {
+		typ := c.checkExpr(s.superclass)
+		switch {
+		case typ.kind == TYPECLASS && !typ.class.trait && !typ.class.enum:
+			ct.superclass = typ.class
+		case typ.kind == TYPEANY:
+			ct.dynamic = true
+		default:
+			c.error(s.superclass.name.line, "Superclass must be a class.")
+		}
+	}
+	c.classTraits(ct, s.traits)
+	c.declare(s.name, &LoxType{kind: TYPECLASS, class: ct}, true)
+
+	for k, field := range s.fields {
+		ct.fields[field.lexeme] = c.resolveType(s.fieldTypes[k])
+		ct.declared[field.lexeme] = true
+	}
+	fns := make([]*funcType, len(s.methods))
+	for k, method := range s.methods {
+		fns[k] = c.functionType(method)
+		ct.methods[method.name.lexeme] = fns[k]
+	}
+
+	enclosingClass, enclosingThis := c.currentClass, c.this
+	c.currentClass, c.this = ct, ct.instance()
+	for k, method := range s.methods {
+		returns := fns[k].returns
+		// an initializer returns this whatever its return statements say
+		if method.name.lexeme == "init" {
+			fns[k].returns = c.this
+			returns = anyType
+		}
+		c.checkFunction(method, fns[k], returns)
+	}
+	c.currentClass, c.this = enclosingClass, enclosingThis
+	return nil
+}
+
+func (c *TypeChecker) VisitAssignExpr(expr Expr) any {
+	e, ok := expr.(*Assign)
+	if !ok {
+		panic("should be assign type expr")
+	}
+	typ := c.checkExpr(e.value)
+	b := c.lookup(e.name.lexeme)
+	if b == nil {
+		return typ
+	}
+	if b.annotated {
+		c.expect(e.name.line, b.typ, typ, fmt.Sprintf("variable '%s'", e.name.lexeme))
+	} else if !sameType(b.typ, typ) {
+		c.widened[b.decl] = true
+		b.typ = anyType
+	}
+	return typ
+}
+
+func (c *TypeChecker) VisitVariableExpr(expr Expr) any {
+	e, ok := expr.(*Variable)
+	if !ok {
+		panic("should be variable type expr")
+	}
+	if b := c.lookup(e.name.lexeme); b != nil {
+		return b.typ
+	}
+	return anyType
+}
+
+func (c *TypeChecker) VisitLiteralExpr(expr Expr) any {
+	e, ok := expr.(*Literal)
+	if !ok {
+		panic("should be literal type expr")
+	}
+	switch e.value.(type) {
+	case float64:
+		return numberType
+	case string:
+		return stringType
+	case bool:
+		return boolType
+	case nil:
+		return nilType
+	}
+	return anyType
+}
+
+func (c *TypeChecker) VisitGroupingExpr(expr Expr) any {
+	e, ok := expr.(*Grouping)
+	if !ok {
+		panic("should be grouping type expr")
+	}
+	return c.checkExpr(e.expression)
+}
+
+// expectNumber reports an operand which can't be a number, instances may
+// overload the operator.
+func (c *TypeChecker) expectNumber(operator *Token, typ *LoxType) {
+	switch typ.kind {
+	case TYPEANY, TYPENUMBER, TYPEINSTANCE:
+		return
+	}
+	c.error(operator.line, fmt.Sprintf("Operand of '%s' must be a number but got %s.", operator.lexeme, typ))
+}
+
+func (c *TypeChecker) VisitUnaryExpr(expr Expr) any {
+	e, ok := expr.(*Unary)
+	if !ok {
+		panic("should be unary type expr")
+	}
+	typ := c.checkExpr(e.right)
+	if e.operator.typ == BANG {
+		return boolType
+	}
+	if typ.kind == TYPEINSTANCE || typ.kind == TYPEANY {
+		return anyType
+	}
+	c.expectNumber(e.operator, typ)
+	return numberType
+}
+
+func (c *TypeChecker) VisitBinaryExpr(expr Expr) any {
+	e, ok := expr.(*Binary)
+	if !ok {
+		panic("should be binary type expr")
+	}
+	left := c.checkExpr(e.left)
+	right := c.checkExpr(e.right)
+	switch e.operator.typ {
+	case EQUAL_EQUAL, BANG_EQUAL, INSTANCEOF:
+		return boolType
+	case PLUS:
+		switch left.kind {
+		case TYPESTRING:
+			return stringType
+		case TYPENUMBER:
+			c.expectNumber(e.operator, right)
+			return numberType
+		case TYPEANY, TYPEINSTANCE:
+			return anyType
+		}
+		c.error(e.operator.line, fmt.Sprintf("Operands of '+' must be numbers or strings but got %s.", left))
+		return anyType
+	}
+	// the overloading method decides what an instance operand returns
+	if left.kind == TYPEINSTANCE || left.kind == TYPEANY {
+		return anyType
+	}
+	c.expectNumber(e.operator, left)
+	c.expectNumber(e.operator, right)
+	switch e.operator.typ {
+	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL:
+		return boolType
+	}
+	return numberType
+}
+
+func (c *TypeChecker) VisitLogicalExpr(expr Expr) any {
+	e, ok := expr.(*Logical)
+	if !ok {
+		panic("should be logical type expr")
+	}
+	left := c.checkExpr(e.left)
+	right := c.checkExpr(e.right)
+	if e.operator.typ == QUESTION_QUESTION && left.kind == TYPENIL {
+		return right
+	}
+	if sameType(left, right) {
+		return left
+	}
+	return anyType
+}
+
+func (c *TypeChecker) VisitCallExpr(expr Expr) any {
+	e, ok := expr.(*Call)
+	if !ok {
+		panic("should be call type expr")
+	}
+	callee := c.checkExpr(e.callee)
+	args := make([]*LoxType, len(e.arguments))
+	for k, arg := range e.arguments {
+		args[k] = c.checkExpr(arg)
+	}
+	switch callee.kind {
+	case TYPEANY:
+		return anyType
+	case TYPEFUNCTION:
+		if callee.fn == nil {
+			return anyType
+		}
+		c.checkArgs(callee.fn, e, args)
+		return callee.fn.returns
+	case TYPECLASS:
+		ct := callee.class
+		if ct.trait || ct.enum {
+			break
+		}
+		if init := ct.findMethod("init"); init != nil {
+			c.checkArgs(init, e, args)
+		} else if !ct.isDynamic() {
+			c.checkArgs(&funcType{name: ct.name}, e, args)
+		}
+		return ct.instance()
+	}
+	c.error(e.paren.line, fmt.Sprintf("Can only call functions and classes but got %s.", callee))
+	return anyType
+}
+
+// checkArgs checks the arguments of a call like bindKeywordArgs binds them:
+// keyword arguments go to the parameter they name.
+func (c *TypeChecker) checkArgs(fn *funcType, e *Call, args []*LoxType) {
+	bound := make([]bool, len(fn.params))
+	n := 0
+	for k, arg := range args {
+		idx := k
+		// names is nil when no argument is passed by keyword
+		if e.names != nil && e.names[k] != nil {
+			name := e.names[k]
+			idx = -1
+			for j, param := range fn.params {
+				if param.lexeme == name.lexeme {
+					idx = j
+					break
+				}
+			}
+			if idx < 0 {
+				c.error(name.line, fmt.Sprintf("Unknown parameter '%s' of '%s'.", name.lexeme, fn.name))
+				continue
+			}
+		}
+		if idx+1 > n {
+			n = idx + 1
+		}
+		if idx >= len(fn.paramTypes) {
+			if fn.restType != nil && (e.names == nil || e.names[k] == nil) {
+				c.expect(e.paren.line, fn.restType, arg, fmt.Sprintf("argument %v of '%s'", idx+1, fn.name))
+			}
+			continue
+		}
+		if idx < len(bound) {
+			bound[idx] = true
+		}
+		what := fmt.Sprintf("argument %v of '%s'", idx+1, fn.name)
+		if idx < len(fn.params) {
+			what = fmt.Sprintf("argument '%s' of '%s'", fn.params[idx].lexeme, fn.name)
+		}
+		c.expect(e.paren.line, fn.paramTypes[idx], arg, what)
+	}
+	if msg := arityText(fn.min, fn.max, n); msg != "" {
+		c.error(e.paren.line, fmt.Sprintf("%s calling '%s'.", msg, fn.name))
+		return
+	}
+	for k := 0; k < fn.min && k < len(bound); k++ {
+		if !bound[k] {
+			c.error(e.paren.line, fmt.Sprintf("Missing argument for parameter '%s'.", fn.params[k].lexeme))
+		}
+	}
+}
+
+func (c *TypeChecker) VisitGetExpr(expr Expr) any {
+	e, ok := expr.(*Get)
+	if !ok {
+		panic("should be get type expr")
+	}
+	object := c.checkExpr(e.object)
+	if e.optional && object.kind == TYPENIL {
+		return anyType
+	}
+	switch object.kind {
+	case TYPEINSTANCE:
+		ct := object.class
+		if typ, owner := ct.findField(e.name.lexeme); owner != nil {
+			return typ
+		}
+		if fn := ct.findMethod(e.name.lexeme); fn != nil {
+			return &LoxType{kind: TYPEFUNCTION, fn: fn}
+		}
+		if !ct.isDynamic() {
+			c.error(e.name.line, fmt.Sprintf("Undefined property '%s' on %s.", e.name.lexeme, ct.name))
+		}
+	case TYPELIST:
+		if fn, ok := listMethods[e.name.lexeme]; ok {
+			return &LoxType{kind: TYPEFUNCTION, fn: fn}
+		}
+		c.error(e.name.line, fmt.Sprintf("Undefined property '%s' on List.", e.name.lexeme))
+	case TYPENUMBER, TYPESTRING, TYPEBOOL, TYPENIL, TYPEFUNCTION:
+		c.error(e.name.line, fmt.Sprintf("Only instances have properties but got %s.", object))
+	}
+	return anyType
+}
+
+func (c *TypeChecker) VisitSetExpr(expr Expr) any {
+	e, ok := expr.(*Set)
+	if !ok {
+		panic("should be set type expr")
+	}
+	object := c.checkExpr(e.object)
+	value := c.checkExpr(e.value)
+	switch object.kind {
+	case TYPEINSTANCE:
+		c.assignField(object.class, e.name, value)
+	case TYPENUMBER, TYPESTRING, TYPEBOOL, TYPENIL, TYPELIST, TYPEFUNCTION:
+		c.error(e.name.line, fmt.Sprintf("Only instances have fields but got %s.", object))
+	}
+	return value
+}
+
+// assignField checks an assignment to a field, the fields a class doesn't
+// declare get the type of the values assigned to them.
+func (c *TypeChecker) assignField(ct *classType, name *Token, value *LoxType) {
+	typ, owner := ct.findField(name.lexeme)
+	switch {
+	case owner != nil && owner.declared[name.lexeme]:
+		c.expect(name.line, typ, value, fmt.Sprintf("field '%s' of %s", name.lexeme, owner.name))
+	case ct.sealed():
+		c.error(name.line, fmt.Sprintf("Undeclared field '%s' on %s.", name.lexeme, ct.name))
+	case owner != nil:
+		if !sameType(typ, value) {
+			owner.fields[name.lexeme] = anyType
+		}
+	default:
+		// the field goes to the root of the hierarchy, the methods of a
+		// superclass may read the fields set on the instances of its
+		// subclasses
+		root := ct
+		for root.superclass != nil {
+			root = root.superclass
+		}
+		if value.kind == TYPENIL {
+			value = anyType
+		}
+		root.fields[name.lexeme] = value
+	}
+}
+
+func (c *TypeChecker) VisitSuperExpr(expr Expr) any {
+	e, ok := expr.(*Super)
+	if !ok {
+		panic("should be super type expr")
+	}
+	if c.currentClass == nil || c.currentClass.superclass == nil {
+		return anyType
+	}
+	if fn := c.currentClass.superclass.findMethod(e.method.lexeme); fn != nil {
+		return &LoxType{kind: TYPEFUNCTION, fn: fn}
+	}
+	if !c.currentClass.superclass.isDynamic() {
+		c.error(e.method.line, fmt.Sprintf("Undefined method '%s' on %s.", e.method.lexeme, c.currentClass.superclass.name))
+	}
+	return anyType
+}
+
+func (c *TypeChecker) VisitThisExpr(expr Expr) any {
+	if c.this == nil {
+		return anyType
+	}
+	return c.this
+}
+
+func (c *TypeChecker) VisitChainExpr(expr Expr) any {
+	e, ok := expr.(*Chain)
+	if !ok {
+		panic("should be chain type expr")
+	}
+	c.checkExpr(e.expression)
+	// any hop may have been skipped
+	return anyType
+}
+
+func (c *TypeChecker) VisitSpawnExpr(expr Expr) any {
+	e, ok := expr.(*Spawn)
+	if !ok {
+		panic("should be spawn type expr")
+	}
+	c.checkExpr(e.call)
+	return anyType
+}
+
+func (c *TypeChecker) VisitMatchExpr(expr Expr) any {
+	e, ok := expr.(*Match)
+	if !ok {
+		panic("should be match type expr")
+	}
+	c.checkExpr(e.subject)
+	var result *LoxType
+	for _, arm := range e.arms {
+		c.beginScope()
+		for _, pattern := range arm.patterns {
+			c.checkPattern(pattern)
+		}
+		if arm.body != nil {
+			arm.body.Accept(c)
+		} else {
+			typ := c.checkExpr(arm.value)
+			if result == nil {
+				result = typ
+			} else if !sameType(result, typ) {
+				result = anyType
+			}
+		}
+		c.endScope()
+	}
+	if result == nil {
+		return anyType
+	}
+	return result
+}
+
+func (c *TypeChecker) checkPattern(pattern Pattern) {
+	pattern.Accept(c)
+}
+
+func (c *TypeChecker) VisitValuePattern(pattern Pattern) any {
+	p, ok := pattern.(*Value)
+	if !ok {
+		panic("should be value type pattern")
+	}
+	c.checkExpr(p.value)
+	return nil
+}
+
+func (c *TypeChecker) VisitBindPattern(pattern Pattern) any {
+	p, ok := pattern.(*Bind)
+	if !ok {
+		panic("should be bind type pattern")
+	}
+	c.declare(p.name, anyType, true)
+	return nil
+}
+
+func (c *TypeChecker) VisitSequencePattern(pattern Pattern) any {
+	p, ok := pattern.(*Sequence)
+	if !ok {
+		panic("should be sequence type pattern")
+	}
+	for _, el := range p.elements {
+		c.checkPattern(el)
+	}
+	if p.rest != nil {
+		c.declare(p.rest, listType, true)
+	}
+	return nil
+}
+
+func (c *TypeChecker) VisitInstancePattern(pattern Pattern) any {
+	p, ok := pattern.(*Instance)
+	if !ok {
+		panic("should be instance type pattern")
+	}
+	c.checkExpr(p.class)
+	for _, sub := range p.patterns {
+		c.checkPattern(sub)
+	}
+	return nil
+}
+
+func (c *TypeChecker) VisitFieldsPattern(pattern Pattern) any {
+	p, ok := pattern.(*Fields)
+	if !ok {
+		panic("should be fields type pattern")
+	}
+	for _, sub := range p.patterns {
+		c.checkPattern(sub)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheck type checks the scripts of testdata/check and compares the
+// errors with the .out file next to them.
+func TestCheck(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "check", "*.lox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".lox"), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inter := NewInterpreter()
+			defer inter.Close()
+			errors := NewTypeChecker(inter).Check(parse(t, inter, string(source)))
+			got := ""
+			for _, msg := range errors {
+				got += msg + "\n"
+			}
+			golden := strings.TrimSuffix(file, ".lox") + ".out"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(want), got)
+		})
+	}
+}
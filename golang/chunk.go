@@ -0,0 +1,150 @@
+package main
+
+type OpCode byte
+
+const (
+	OP_CONSTANT OpCode = iota
+	OP_NIL
+	OP_TRUE
+	OP_FALSE
+	OP_POP
+	OP_GET_LOCAL
+	OP_SET_LOCAL
+	OP_GET_GLOBAL
+	OP_DEFINE_GLOBAL
+	OP_DEFINE_CONST
+	OP_SET_GLOBAL
+	OP_GET_UPVALUE
+	OP_SET_UPVALUE
+	OP_GET_PROPERTY
+	OP_SET_PROPERTY
+	OP_GET_SUPER
+	OP_EQUAL
+	OP_GREATER
+	OP_GREATER_EQUAL
+	OP_LESS
+	OP_LESS_EQUAL
+	OP_INSTANCEOF
+	OP_ADD
+	OP_SUBTRACT
+	OP_MULTIPLY
+	OP_DIVIDE
+	OP_NOT
+	OP_NEGATE
+	OP_PRINT
+	OP_JUMP
+	OP_JUMP_IF_FALSE
+	OP_JUMP_IF_NIL
+	OP_LOOP
+	OP_CALL
+	OP_CLOSURE
+	OP_CLOSE_UPVALUE
+	OP_RETURN
+	OP_CLASS
+	OP_INHERIT
+	OP_METHOD
+	OP_ENUM
+	OP_DESTRUCTURE
+	OP_CALL_KEYWORDS
+	OP_JUMP_IF_PASSED
+	OP_MATCH
+	OP_TRAIT
+	OP_WITH
+	OP_GET_PRIVATE
+	OP_SET_PRIVATE
+	OP_YIELD
+	OP_TAIL_CALL
+	OP_TAIL_CALL_KEYWORDS
+	OP_SPAWN
+	OP_SPAWN_KEYWORDS
+)
+
+var opNames = map[OpCode]string{
+	OP_CONSTANT:           "OP_CONSTANT",
+	OP_NIL:                "OP_NIL",
+	OP_TRUE:               "OP_TRUE",
+	OP_FALSE:              "OP_FALSE",
+	OP_POP:                "OP_POP",
+	OP_GET_LOCAL:          "OP_GET_LOCAL",
+	OP_SET_LOCAL:          "OP_SET_LOCAL",
+	OP_GET_GLOBAL:         "OP_GET_GLOBAL",
+	OP_DEFINE_GLOBAL:      "OP_DEFINE_GLOBAL",
+	OP_DEFINE_CONST:       "OP_DEFINE_CONST",
+	OP_SET_GLOBAL:         "OP_SET_GLOBAL",
+	OP_GET_UPVALUE:        "OP_GET_UPVALUE",
+	OP_SET_UPVALUE:        "OP_SET_UPVALUE",
+	OP_GET_PROPERTY:       "OP_GET_PROPERTY",
+	OP_SET_PROPERTY:       "OP_SET_PROPERTY",
+	OP_GET_SUPER:          "OP_GET_SUPER",
+	OP_EQUAL:              "OP_EQUAL",
+	OP_GREATER:            "OP_GREATER",
+	OP_GREATER_EQUAL:      "OP_GREATER_EQUAL",
+	OP_LESS:               "OP_LESS",
+	OP_LESS_EQUAL:         "OP_LESS_EQUAL",
+	OP_INSTANCEOF:         "OP_INSTANCEOF",
+	OP_ADD:                "OP_ADD",
+	OP_SUBTRACT:           "OP_SUBTRACT",
+	OP_MULTIPLY:           "OP_MULTIPLY",
+	OP_DIVIDE:             "OP_DIVIDE",
+	OP_NOT:                "OP_NOT",
+	OP_NEGATE:             "OP_NEGATE",
+	OP_PRINT:              "OP_PRINT",
+	OP_JUMP:               "OP_JUMP",
+	OP_JUMP_IF_FALSE:      "OP_JUMP_IF_FALSE",
+	OP_JUMP_IF_NIL:        "OP_JUMP_IF_NIL",
+	OP_LOOP:               "OP_LOOP",
+	OP_CALL:               "OP_CALL",
+	OP_CLOSURE:            "OP_CLOSURE",
+	OP_CLOSE_UPVALUE:      "OP_CLOSE_UPVALUE",
+	OP_RETURN:             "OP_RETURN",
+	OP_CLASS:              "OP_CLASS",
+	OP_INHERIT:            "OP_INHERIT",
+	OP_METHOD:             "OP_METHOD",
+	OP_ENUM:               "OP_ENUM",
+	OP_DESTRUCTURE:        "OP_DESTRUCTURE",
+	OP_CALL_KEYWORDS:      "OP_CALL_KEYWORDS",
+	OP_JUMP_IF_PASSED:     "OP_JUMP_IF_PASSED",
+	OP_MATCH:              "OP_MATCH",
+	OP_TRAIT:              "OP_TRAIT",
+	OP_WITH:               "OP_WITH",
+	OP_GET_PRIVATE:        "OP_GET_PRIVATE",
+	OP_SET_PRIVATE:        "OP_SET_PRIVATE",
+	OP_YIELD:              "OP_YIELD",
+	OP_TAIL_CALL:          "OP_TAIL_CALL",
+	OP_TAIL_CALL_KEYWORDS: "OP_TAIL_CALL_KEYWORDS",
+	OP_SPAWN:              "OP_SPAWN",
+	OP_SPAWN_KEYWORDS:     "OP_SPAWN_KEYWORDS",
+}
+
+func (op OpCode) String() string {
+	return opNames[op]
+}
+
+// Chunk is the bytecode of a function. Operands follow their opcode, the
+// constants and jump offsets take two bytes, big endian.
+type Chunk struct {
+	code []byte
+	// lines[k] is the source line of code[k]
+	lines     []int
+	constants []any
+}
+
+func (c *Chunk) Write(b byte, line int) {
+	c.code = append(c.code, b)
+	c.lines = append(c.lines, line)
+}
+
+// AddConstant returns the index of value in the constants table, equal
+// strings and numbers share an entry.
+func (c *Chunk) AddConstant(value any) int {
+	switch value.(type) {
+	case string, float64:
+		for k, v := range c.constants {
+			if v == value {
+				return k
+			}
+		}
+	}
+	c.constants = append(c.constants, value)
+	return len(c.constants) - 1
+}
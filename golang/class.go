@@ -1,24 +1,56 @@
 package main
 
+import (
+	"sort"
+	"strings"
+)
+
 type LoxClass struct {
 	name       string
 	methods    map[string]Callable
 	superclass *LoxClass
+	// the traits included with 'with', their methods are already merged
+	// into methods
+	traits []*LoxTrait
 }
 
-func NewLoxClass(name string, superclass *LoxClass, methods map[string]Callable) *LoxClass {
+func NewLoxClass(name string, superclass *LoxClass, traits []*LoxTrait, methods map[string]Callable) *LoxClass {
 	return &LoxClass{
 		name:       name,
 		methods:    methods,
 		superclass: superclass,
+		traits:     traits,
 	}
 }
 
-func (lc *LoxClass) Arity() int {
-	return 0
+func (lc *LoxClass) Arity() (int, int) {
+	if init := lc.initializer(); init != nil {
+		return init.Arity()
+	}
+	return 0, 0
 }
-func (lc *LoxClass) Call(*Interpreter, []any) any {
-	return NewLoxInstance(lc)
+
+func (lc *LoxClass) Call(i *Interpreter, args []any) any {
+	i.allocate(instanceSize)
+	instance := NewLoxInstance(lc)
+	if init := lc.initializer(); init != nil {
+		init.Bind(instance).Call(i, args)
+	}
+	return instance
+}
+
+func (lc *LoxClass) Params() []*Token {
+	if init, ok := lc.initializer().(keywordCallable); ok {
+		return init.Params()
+	}
+	return nil
+}
+
+func (lc *LoxClass) initializer() Callable {
+	if init, ok := lc.FindMethod(&Token{lexeme: "init"}).(Callable); ok {
+		return init
+	}
+	return nil
 }
 
 func (lc *LoxClass) ToString() string {
@@ -38,3 +70,42 @@ func (lc *LoxClass) FindMethod(name *Token) any {
 	}
 	return nil
 }
+
+// IsSubclassOf reports whether lc is other or inherits from it.
+func (lc *LoxClass) IsSubclassOf(other *LoxClass) bool {
+	for c := lc; c != nil; c = c.superclass {
+		if c == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Includes reports whether lc or one of its superclasses includes the trait.
+func (lc *LoxClass) Includes(trait *LoxTrait) bool {
+	for c := lc; c != nil; c = c.superclass {
+		for _, t := range c.traits {
+			if t.Includes(trait) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MethodNames returns the names of the public methods of lc, including the
+// inherited ones.
+func (lc *LoxClass) MethodNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for c := lc; c != nil; c = c.superclass {
+		for name := range c.methods {
+			if !seen[name] && !strings.HasPrefix(name, "#") {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,979 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+type FunctionKind int
+
+const (
+	KIND_SCRIPT FunctionKind = iota
+	KIND_FUNCTION
+	KIND_METHOD
+	KIND_INITIALIZER
+)
+
+type local struct {
+	name string
+	// -1 until the initializer is compiled
+	depth    int
+	captured bool
+	constant bool
+}
+
+type upvalueRef struct {
+	index   byte
+	isLocal bool
+}
+
+type classCompiler struct {
+	enclosing     *classCompiler
+	hasSuperclass bool
+}
+
+// Compiler turns the resolved AST into the bytecode run by the VM, one
+// compiler per function being compiled.
+type Compiler struct {
+	enclosing  *Compiler
+	function   *ObjFunction
+	kind       FunctionKind
+	locals     []local
+	upvalues   []upvalueRef
+	scopeDepth int
+	class      *classCompiler
+	// the line of the bytes being emitted
+	line int
+	// the global constants, shared by every compiler
+	globalConsts map[string]bool
+	// the pending jumps to the end of each enclosing '?.' chain
+	chainExits [][]int
+	// the call being compiled is the value of a return, it replaces the
+	// frame of the returning function
+	tail bool
+	// the operands on the stack above the locals, evaluated before the
+	// expression being compiled
+	temps int
+}
+
+func newCompiler(enclosing *Compiler, kind FunctionKind, name string) *Compiler {
+	c := &Compiler{
+		enclosing: enclosing,
+		function:  &ObjFunction{name: name},
+		kind:      kind,
+	}
+	if enclosing != nil {
+		c.class = enclosing.class
+		c.line = enclosing.line
+		c.globalConsts = enclosing.globalConsts
+	}
+	// slot 0 holds the function itself, or the instance in methods
+	slot := ""
+	if kind == KIND_METHOD || kind == KIND_INITIALIZER {
+		slot = "this"
+	}
+	c.locals = append(c.locals, local{name: slot})
+	return c
+}
+
+// Compile returns the script function of the statements, nil is returned
+// after reporting a compile error. With echo the value of a trailing
+// expression statement is returned by the script.
+func Compile(stmts []Stmt, globalConsts map[string]bool, echo bool) (fn *ObjFunction) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println(err)
+			hadError = true
+			fn = nil
+		}
+	}()
+	c := newCompiler(nil, KIND_SCRIPT, "")
+	c.globalConsts = globalConsts
+	for k, stmt := range stmts {
+		if s, ok := stmt.(*Expression); ok && echo && k == len(stmts)-1 {
+			c.compileExpr(s.expr)
+			c.emitOp(OP_RETURN)
+			return c.function
+		}
+		c.compileStmt(stmt)
+	}
+	c.emitReturn()
+	return c.function
+}
+
+func (c *Compiler) chunk() *Chunk {
+	return &c.function.chunk
+}
+
+func (c *Compiler) emitByte(b byte) {
+	c.chunk().Write(b, c.line)
+}
+
+func (c *Compiler) emitOp(op OpCode) {
+	c.emitByte(byte(op))
+}
+
+func (c *Compiler) emitShort(v int) {
+	c.emitByte(byte(v >> 8))
+	c.emitByte(byte(v))
+}
+
+func (c *Compiler) emitOpByte(op OpCode, b byte) {
+	c.emitOp(op)
+	c.emitByte(b)
+}
+
+func (c *Compiler) emitOpShort(op OpCode, v int) {
+	c.emitOp(op)
+	c.emitShort(v)
+}
+
+func (c *Compiler) emitReturn() {
+	if c.kind == KIND_INITIALIZER {
+		c.emitOpByte(OP_GET_LOCAL, 0)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+	c.emitOp(OP_RETURN)
+}
+
+func (c *Compiler) makeConstant(value any) int {
+	idx := c.chunk().AddConstant(value)
+	if idx > math.MaxUint16 {
+		Panic(c.line, "Too many constants in one chunk.")
+	}
+	return idx
+}
+
+func (c *Compiler) emitConstant(value any) {
+	c.emitOpShort(OP_CONSTANT, c.makeConstant(value))
+}
+
+// emitJump emits a jump with a placeholder offset and returns where the
+// offset is so patchJump can fill it.
+func (c *Compiler) emitJump(op OpCode) int {
+	c.emitOpShort(op, 0xffff)
+	return len(c.chunk().code) - 2
+}
+
+func (c *Compiler) patchJump(offset int) {
+	jump := len(c.chunk().code) - offset - 2
+	if jump > math.MaxUint16 {
+		Panic(c.line, "Too much code to jump over.")
+	}
+	c.chunk().code[offset] = byte(jump >> 8)
+	c.chunk().code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) emitLoop(start int) {
+	c.emitOp(OP_LOOP)
+	offset := len(c.chunk().code) - start + 2
+	if offset > math.MaxUint16 {
+		Panic(c.line, "Loop body too large.")
+	}
+	c.emitShort(offset)
+}
+
+func (c *Compiler) compileStmt(stmt Stmt) {
+	stmt.Accept(c)
+}
+
+func (c *Compiler) compileStmts(stmts []Stmt) {
+	for _, stmt := range stmts {
+		stmt.Accept(c)
+	}
+}
+
+func (c *Compiler) compileExpr(expr Expr) {
+	expr.Accept(c)
+}
+
+// compileOperand compiles expr while n more operands are on the stack.
+func (c *Compiler) compileOperand(expr Expr, n int) {
+	c.temps += n
+	c.compileExpr(expr)
+	c.temps -= n
+}
+
+func (c *Compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *Compiler) endScope() {
+	c.scopeDepth--
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		if c.locals[len(c.locals)-1].captured {
+			c.emitOp(OP_CLOSE_UPVALUE)
+		} else {
+			c.emitOp(OP_POP)
+		}
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+func (c *Compiler) addLocal(name *Token, constant bool) {
+	if len(c.locals) > math.MaxUint8 {
+		Panic(name.line, "Too many local variables in function.")
+	}
+	c.locals = append(c.locals, local{name: name.lexeme, depth: -1, constant: constant})
+}
+
+func (c *Compiler) markInitialized() {
+	if c.scopeDepth == 0 {
+		return
+	}
+	c.locals[len(c.locals)-1].depth = c.scopeDepth
+}
+
+// declareVariable adds a local, globals are late bound and need nothing.
+func (c *Compiler) declareVariable(name *Token, constant bool) {
+	if c.scopeDepth == 0 {
+		if constant {
+			c.globalConsts[name.lexeme] = true
+		} else {
+			delete(c.globalConsts, name.lexeme)
+		}
+		return
+	}
+	c.addLocal(name, constant)
+}
+
+// defineVariable makes the value on top of the stack the variable. Constant
+// globals are enforced when run too, functions compiled before their
+// declaration may assign them.
+func (c *Compiler) defineVariable(name *Token, constant bool) {
+	if c.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	if constant {
+		c.emitOpShort(OP_DEFINE_CONST, c.makeConstant(name.lexeme))
+		return
+	}
+	c.emitOpShort(OP_DEFINE_GLOBAL, c.makeConstant(name.lexeme))
+}
+
+func (c *Compiler) resolveLocal(name string) int {
+	for k := len(c.locals) - 1; k >= 0; k-- {
+		if c.locals[k].name == name {
+			return k
+		}
+	}
+	return -1
+}
+
+func (c *Compiler) addUpvalue(index byte, isLocal bool) int {
+	for k, up := range c.upvalues {
+		if up.index == index && up.isLocal == isLocal {
+			return k
+		}
+	}
+	if len(c.upvalues) > math.MaxUint8 {
+		Panic(c.line, "Too many closure variables in function.")
+	}
+	c.upvalues = append(c.upvalues, upvalueRef{index: index, isLocal: isLocal})
+	return len(c.upvalues) - 1
+}
+
+// resolveUpvalue returns the upvalue capturing a local of an enclosing
+// function, the local is reported in the second result.
+func (c *Compiler) resolveUpvalue(name string) (int, *local) {
+	if c.enclosing == nil {
+		return -1, nil
+	}
+	if idx := c.enclosing.resolveLocal(name); idx >= 0 {
+		c.enclosing.locals[idx].captured = true
+		return c.addUpvalue(byte(idx), true), &c.enclosing.locals[idx]
+	}
+	if idx, l := c.enclosing.resolveUpvalue(name); idx >= 0 {
+		return c.addUpvalue(byte(idx), false), l
+	}
+	return -1, nil
+}
+
+func (c *Compiler) namedVariable(name *Token, value Expr) {
+	getOp, setOp := OP_GET_GLOBAL, OP_SET_GLOBAL
+	var arg int
+	constant := false
+	if idx := c.resolveLocal(name.lexeme); idx >= 0 {
+		getOp, setOp = OP_GET_LOCAL, OP_SET_LOCAL
+		arg = idx
+		constant = c.locals[idx].constant
+	} else if idx, l := c.resolveUpvalue(name.lexeme); idx >= 0 {
+		getOp, setOp = OP_GET_UPVALUE, OP_SET_UPVALUE
+		arg = idx
+		constant = l.constant
+	} else {
+		arg = c.makeConstant(name.lexeme)
+		constant = c.globalConsts[name.lexeme]
+	}
+	op := getOp
+	if value != nil {
+		if constant {
+			Panic(name.line, fmt.Sprintf("Can't assign to constant '%s'.", name.lexeme))
+		}
+		c.compileExpr(value)
+		op = setOp
+	}
+	c.line = name.line
+	if op == OP_GET_GLOBAL || op == OP_SET_GLOBAL {
+		c.emitOpShort(op, arg)
+	} else {
+		c.emitOpByte(op, byte(arg))
+	}
+}
+
+func (c *Compiler) VisitExpressionStmt(stmt Stmt) any {
+	s, ok := stmt.(*Expression)
+	if !ok {
+		panic("should be expression type stmt")
+	}
+	c.compileExpr(s.expr)
+	c.emitOp(OP_POP)
+	return nil
+}
+
+func (c *Compiler) VisitPrintStmt(stmt Stmt) any {
+	s, ok := stmt.(*Print)
+	if !ok {
+		panic("should be print type stmt")
+	}
+	c.compileExpr(s.expr)
+	c.emitOp(OP_PRINT)
+	return nil
+}
+
+func (c *Compiler) VisitVarStmt(stmt Stmt) any {
+	s, ok := stmt.(*Var)
+	if !ok {
+		panic("should be variable type stmt")
+	}
+	c.line = s.name.line
+	// the Resolver rejects initializers reading the variable, which is
+	// declared once its value is on the stack
+	if s.initializer != nil {
+		c.compileExpr(s.initializer)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+	c.declareVariable(s.name, s.constant)
+	c.defineVariable(s.name, s.constant)
+	return nil
+}
+
+func (c *Compiler) VisitBlockStmt(stmt Stmt) any {
+	s, ok := stmt.(*Block)
+	if !ok {
+		panic("should be block type stmt")
+	}
+	c.beginScope()
+	c.compileStmts(s.statements)
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) VisitIfStmt(stmt Stmt) any {
+	s, ok := stmt.(*If)
+	if !ok {
+		panic("should be if type stmt")
+	}
+	c.compileExpr(s.condition)
+	thenJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.compileStmt(s.thenBranch)
+	elseJump := c.emitJump(OP_JUMP)
+	c.patchJump(thenJump)
+	c.emitOp(OP_POP)
+	if s.elseBranch != nil {
+		c.compileStmt(s.elseBranch)
+	}
+	c.patchJump(elseJump)
+	return nil
+}
+
+func (c *Compiler) VisitWhileStmt(stmt Stmt) any {
+	s, ok := stmt.(*While)
+	if !ok {
+		panic("should be while type stmt")
+	}
+	loopStart := len(c.chunk().code)
+	c.compileExpr(s.condition)
+	exitJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.compileStmt(s.body)
+	c.emitLoop(loopStart)
+	c.patchJump(exitJump)
+	c.emitOp(OP_POP)
+	return nil
+}
+
+func (c *Compiler) VisitFunctionStmt(stmt Stmt) any {
+	s, ok := stmt.(*Function)
+	if !ok {
+		panic("should be function type stmt")
+	}
+	c.line = s.name.line
+	c.declareVariable(s.name, false)
+	// the function can refer to itself
+	c.markInitialized()
+	c.compileFunction(s, KIND_FUNCTION)
+	c.defineVariable(s.name, false)
+	return nil
+}
+
+// compileFunction compiles the declaration into a new function and emits the
+// closure creating it.
+func (c *Compiler) compileFunction(s *Function, kind FunctionKind) {
+	fc := newCompiler(c, kind, s.name.lexeme)
+	fc.beginScope()
+	fc.function.generator = s.generator
+	fc.function.arity = len(s.params)
+	fc.function.variadic = s.rest != nil
+	fc.function.params = s.params
+	for _, param := range s.params {
+		fc.addLocal(param, false)
+		fc.markInitialized()
+	}
+	if s.rest != nil {
+		fc.addLocal(s.rest, false)
+		fc.markInitialized()
+	}
+	// parameters with default values can only follow the required ones
+	for _, d := range s.defaults {
+		if d != nil {
+			break
+		}
+		fc.function.required++
+	}
+	// a default value is evaluated when the argument is left out, after the
+	// parameters before it are bound
+	for k, d := range s.defaults {
+		if d == nil {
+			continue
+		}
+		fc.emitOpByte(OP_JUMP_IF_PASSED, byte(k+1))
+		fc.emitShort(0xffff)
+		skip := len(fc.chunk().code) - 2
+		fc.compileExpr(d)
+		fc.emitOpByte(OP_SET_LOCAL, byte(k+1))
+		fc.emitOp(OP_POP)
+		fc.patchJump(skip)
+	}
+	for k, pattern := range s.patterns {
+		if pattern != nil {
+			fc.emitOpByte(OP_GET_LOCAL, byte(k+1))
+			fc.destructure(pattern, false)
+		}
+	}
+	fc.compileStmts(s.body)
+	fc.emitReturn()
+	c.emitClosure(fc)
+}
+
+// emitClosure emits the closure creating the function fc compiled.
+func (c *Compiler) emitClosure(fc *Compiler) {
+	fn := fc.function
+	fn.upvalueCount = len(fc.upvalues)
+	c.emitOpShort(OP_CLOSURE, c.makeConstant(fn))
+	for _, up := range fc.upvalues {
+		if up.isLocal {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitByte(up.index)
+	}
+}
+
+func (c *Compiler) VisitReturnStmt(stmt Stmt) any {
+	s, ok := stmt.(*Return)
+	if !ok {
+		panic("should be return type stmt")
+	}
+	c.line = s.keyword.line
+	if s.value == nil {
+		c.emitReturn()
+		return nil
+	}
+	// like the tree walker, 'return f(x);' is a tail call
+	if _, ok := s.value.(*Call); ok {
+		c.tail = true
+	}
+	c.compileExpr(s.value)
+	c.emitOp(OP_RETURN)
+	return nil
+}
+
+func (c *Compiler) VisitClassStmt(stmt Stmt) any {
+	s, ok := stmt.(*Class)
+	if !ok {
+		panic("should be class type stmt")
+	}
+	c.line = s.name.line
+	nameConstant := c.makeConstant(s.name.lexeme)
+	c.declareVariable(s.name, false)
+	c.emitOpShort(OP_CLASS, nameConstant)
+	c.defineVariable(s.name, false)
+
+	class := &classCompiler{enclosing: c.class}
+	c.class = class
+	defer func() {
+		c.class = class.enclosing
+	}()
+
+	if s.superclass != nil {
+		c.namedVariable(s.superclass.name, nil)
+		c.beginScope()
+		c.addLocal(&Token{lexeme: "super", line: s.name.line}, false)
+		c.markInitialized()
+		c.namedVariable(s.name, nil)
+		c.emitOp(OP_INHERIT)
+		class.hasSuperclass = true
+	}
+
+	// the class stays on the stack while its methods are bound, the methods
+	// capture it to reach the private members it declares
+	c.beginScope()
+	c.namedVariable(s.name, nil)
+	c.addLocal(&Token{lexeme: privateOwner, line: s.name.line}, false)
+	c.markInitialized()
+	c.include(s, s.traits)
+	for _, method := range s.methods {
+		kind := KIND_METHOD
+		if method.name.lexeme == "init" {
+			kind = KIND_INITIALIZER
+		}
+		c.compileFunction(method, kind)
+		c.emitOpShort(OP_METHOD, c.makeConstant(method.name.lexeme))
+	}
+	c.endScope()
+
+	if class.hasSuperclass {
+		c.endScope()
+	}
+	return nil
+}
+
+func (c *Compiler) VisitDestructureStmt(stmt Stmt) any {
+	s, ok := stmt.(*Destructure)
+	if !ok {
+		panic("should be destructure type stmt")
+	}
+	c.compileExpr(s.initializer)
+	c.line = s.keyword.line
+	c.destructure(s.pattern, s.constant)
+	return nil
+}
+
+// destructure replaces the value on top of the stack with the values of the
+// names pattern binds, which become variables.
+func (c *Compiler) destructure(pattern Pattern, constant bool) {
+	names := boundNames(pattern)
+	c.emitOpShort(OP_DESTRUCTURE, c.makeConstant(pattern))
+	for _, name := range names {
+		c.declareVariable(name, constant)
+		c.markInitialized()
+	}
+	if c.scopeDepth == 0 {
+		for k := len(names) - 1; k >= 0; k-- {
+			c.defineVariable(names[k], constant)
+		}
+	}
+}
+
+func (c *Compiler) VisitTraitStmt(stmt Stmt) any {
+	s, ok := stmt.(*Trait)
+	if !ok {
+		panic("should be trait type stmt")
+	}
+	c.line = s.name.line
+	c.declareVariable(s.name, false)
+	c.emitOpShort(OP_TRAIT, c.makeConstant(s.name.lexeme))
+	c.defineVariable(s.name, false)
+
+	class := &classCompiler{enclosing: c.class}
+	c.class = class
+	defer func() {
+		c.class = class.enclosing
+	}()
+
+	c.namedVariable(s.name, nil)
+	c.include(s, s.traits)
+	for _, method := range s.methods {
+		c.compileFunction(method, KIND_METHOD)
+		c.emitOpShort(OP_METHOD, c.makeConstant(method.name.lexeme))
+	}
+	c.emitOp(OP_POP)
+	return nil
+}
+
+// include merges the traits named after 'with' into the class or the trait
+// on top of the stack.
+func (c *Compiler) include(decl Stmt, traits []*Variable) {
+	if len(traits) == 0 {
+		return
+	}
+	for _, trait := range traits {
+		c.compileExpr(trait)
+	}
+	c.emitOpShort(OP_WITH, c.makeConstant(decl))
+}
+
+func (c *Compiler) VisitYieldStmt(stmt Stmt) any {
+	s, ok := stmt.(*Yield)
+	if !ok {
+		panic("should be yield type stmt")
+	}
+	if s.value != nil {
+		c.compileExpr(s.value)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+	c.line = s.keyword.line
+	c.emitOp(OP_YIELD)
+	return nil
+}
+
+func (c *Compiler) VisitEnumStmt(stmt Stmt) any {
+	s, ok := stmt.(*Enum)
+	if !ok {
+		panic("should be enum type stmt")
+	}
+	c.line = s.name.line
+	c.declareVariable(s.name, false)
+	// each run of the declaration makes a new enum
+	c.emitOpShort(OP_ENUM, c.makeConstant(s))
+	c.defineVariable(s.name, false)
+	return nil
+}
+
+func (c *Compiler) VisitLiteralExpr(expr Expr) any {
+	e, ok := expr.(*Literal)
+	if !ok {
+		panic("should be literal type expr")
+	}
+	// a literal made up without a token has no line
+	if e.line != 0 {
+		c.line = e.line
+	}
+	switch e.value {
+	case nil:
+		c.emitOp(OP_NIL)
+	case true:
+		c.emitOp(OP_TRUE)
+	case false:
+		c.emitOp(OP_FALSE)
+	default:
+		c.emitConstant(e.value)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitGroupingExpr(expr Expr) any {
+	c.compileExpr(expr.(*Grouping).expression)
+	return nil
+}
+
+func (c *Compiler) VisitUnaryExpr(expr Expr) any {
+	e, ok := expr.(*Unary)
+	if !ok {
+		panic("should be unary type expr")
+	}
+	c.compileExpr(e.right)
+	c.line = e.operator.line
+	switch e.operator.typ {
+	case BANG:
+		c.emitOp(OP_NOT)
+	case MINUS:
+		c.emitOp(OP_NEGATE)
+	}
+	return nil
+}
+
+var binaryOps = map[TokenType]OpCode{
+	PLUS:          OP_ADD,
+	MINUS:         OP_SUBTRACT,
+	STAR:          OP_MULTIPLY,
+	SLASH:         OP_DIVIDE,
+	EQUAL_EQUAL:   OP_EQUAL,
+	GREATER:       OP_GREATER,
+	GREATER_EQUAL: OP_GREATER_EQUAL,
+	LESS:          OP_LESS,
+	LESS_EQUAL:    OP_LESS_EQUAL,
+	INSTANCEOF:    OP_INSTANCEOF,
+}
+
+func (c *Compiler) VisitBinaryExpr(expr Expr) any {
+	e, ok := expr.(*Binary)
+	if !ok {
+		panic("should be binary type expr")
+	}
+	c.compileExpr(e.left)
+	c.compileOperand(e.right, 1)
+	c.line = e.operator.line
+	if e.operator.typ == BANG_EQUAL {
+		c.emitOp(OP_EQUAL)
+		c.emitOp(OP_NOT)
+		return nil
+	}
+	c.emitOp(binaryOps[e.operator.typ])
+	return nil
+}
+
+func (c *Compiler) VisitLogicalExpr(expr Expr) any {
+	e, ok := expr.(*Logical)
+	if !ok {
+		panic("should be logical type expr")
+	}
+	c.compileExpr(e.left)
+	c.line = e.operator.line
+	switch e.operator.typ {
+	case AND:
+		endJump := c.emitJump(OP_JUMP_IF_FALSE)
+		c.emitOp(OP_POP)
+		c.compileExpr(e.right)
+		c.patchJump(endJump)
+	case OR:
+		elseJump := c.emitJump(OP_JUMP_IF_FALSE)
+		endJump := c.emitJump(OP_JUMP)
+		c.patchJump(elseJump)
+		c.emitOp(OP_POP)
+		c.compileExpr(e.right)
+		c.patchJump(endJump)
+	case QUESTION_QUESTION:
+		nilJump := c.emitJump(OP_JUMP_IF_NIL)
+		endJump := c.emitJump(OP_JUMP)
+		c.patchJump(nilJump)
+		c.emitOp(OP_POP)
+		c.compileExpr(e.right)
+		c.patchJump(endJump)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitVariableExpr(expr Expr) any {
+	c.namedVariable(expr.(*Variable).name, nil)
+	return nil
+}
+
+func (c *Compiler) VisitAssignExpr(expr Expr) any {
+	e, ok := expr.(*Assign)
+	if !ok {
+		panic("should be assign type expr")
+	}
+	c.namedVariable(e.name, e.value)
+	return nil
+}
+
+func (c *Compiler) VisitCallExpr(expr Expr) any {
+	e, ok := expr.(*Call)
+	if !ok {
+		panic("should be call type expr")
+	}
+	tail := c.tail
+	c.tail = false
+	c.compileArguments(e)
+	c.line = e.paren.line
+	if e.names != nil {
+		op := OP_CALL_KEYWORDS
+		if tail {
+			op = OP_TAIL_CALL_KEYWORDS
+		}
+		c.emitOpByte(op, byte(len(e.arguments)))
+		c.emitShort(c.makeConstant(e.names))
+		return nil
+	}
+	op := OP_CALL
+	if tail {
+		op = OP_TAIL_CALL
+	}
+	c.emitOpByte(op, byte(len(e.arguments)))
+	return nil
+}
+
+// compileArguments pushes the callee of the call and its arguments.
+func (c *Compiler) compileArguments(e *Call) {
+	c.compileExpr(e.callee)
+	for k, arg := range e.arguments {
+		c.compileOperand(arg, k+1)
+	}
+	if len(e.arguments) > math.MaxUint8 {
+		Panic(e.paren.line, "Can't have more than 255 arguments.")
+	}
+}
+
+func (c *Compiler) VisitGetExpr(expr Expr) any {
+	e, ok := expr.(*Get)
+	if !ok {
+		panic("should be get type expr")
+	}
+	c.compileExpr(e.object)
+	if e.name.typ == PRIVATE_IDENTIFIER {
+		c.namedVariable(&Token{lexeme: privateOwner, line: e.name.line}, nil)
+		c.line = e.name.line
+		c.emitOpShort(OP_GET_PRIVATE, c.makeConstant(e.name.lexeme))
+		return nil
+	}
+	c.line = e.name.line
+	if e.optional {
+		// the nil object is the value of the whole chain
+		exits := c.chainExits[len(c.chainExits)-1]
+		c.chainExits[len(c.chainExits)-1] = append(exits, c.emitJump(OP_JUMP_IF_NIL))
+	}
+	c.emitOpShort(OP_GET_PROPERTY, c.makeConstant(e.name.lexeme))
+	return nil
+}
+
+func (c *Compiler) VisitChainExpr(expr Expr) any {
+	c.chainExits = append(c.chainExits, nil)
+	c.compileExpr(expr.(*Chain).expression)
+	for _, exit := range c.chainExits[len(c.chainExits)-1] {
+		c.patchJump(exit)
+	}
+	c.chainExits = c.chainExits[:len(c.chainExits)-1]
+	return nil
+}
+
+func (c *Compiler) VisitSetExpr(expr Expr) any {
+	e, ok := expr.(*Set)
+	if !ok {
+		panic("should be set type expr")
+	}
+	c.compileExpr(e.object)
+	if e.name.typ == PRIVATE_IDENTIFIER {
+		c.namedVariable(&Token{lexeme: privateOwner, line: e.name.line}, nil)
+		c.compileOperand(e.value, 2)
+		c.line = e.name.line
+		c.emitOpShort(OP_SET_PRIVATE, c.makeConstant(e.name.lexeme))
+		return nil
+	}
+	c.compileOperand(e.value, 1)
+	c.line = e.name.line
+	c.emitOpShort(OP_SET_PROPERTY, c.makeConstant(e.name.lexeme))
+	return nil
+}
+
+func (c *Compiler) VisitThisExpr(expr Expr) any {
+	c.namedVariable(expr.(*This).keyword, nil)
+	return nil
+}
+
+func (c *Compiler) VisitSuperExpr(expr Expr) any {
+	e, ok := expr.(*Super)
+	if !ok {
+		panic("should be super type expr")
+	}
+	c.namedVariable(&Token{lexeme: "this", line: e.keyword.line}, nil)
+	c.namedVariable(e.keyword, nil)
+	c.line = e.method.line
+	c.emitOpShort(OP_GET_SUPER, c.makeConstant(e.method.lexeme))
+	return nil
+}
+
+func (c *Compiler) VisitSpawnExpr(expr Expr) any {
+	e, ok := expr.(*Spawn)
+	if !ok {
+		panic("should be spawn type expr")
+	}
+	c.compileArguments(e.call)
+	c.line = e.call.paren.line
+	if e.call.names != nil {
+		c.emitOpByte(OP_SPAWN_KEYWORDS, byte(len(e.call.arguments)))
+		c.emitShort(c.makeConstant(e.call.names))
+		return nil
+	}
+	c.emitOpByte(OP_SPAWN, byte(len(e.call.arguments)))
+	return nil
+}
+
+// VisitMatchExpr keeps the subject in a local, which the value of the match
+// replaces. The operands evaluated before the match are on the stack below
+// it, unnamed locals stand for them while its arms are compiled so the
+// variables the arms bind are in the right slots.
+func (c *Compiler) VisitMatchExpr(expr Expr) any {
+	e, ok := expr.(*Match)
+	if !ok {
+		panic("should be match type expr")
+	}
+	temps := c.temps
+	c.temps = 0
+	c.beginScope()
+	for k := 0; k < temps; k++ {
+		c.addLocal(&Token{line: e.keyword.line}, false)
+		c.markInitialized()
+	}
+	c.compileExpr(e.subject)
+	c.addLocal(&Token{line: e.keyword.line}, false)
+	c.markInitialized()
+	c.compileArms(e, len(c.locals)-1)
+	// the subject and the operands stay on the stack
+	c.scopeDepth--
+	c.locals = c.locals[:len(c.locals)-temps-1]
+	c.temps = temps
+	return nil
+}
+
+// compileArms runs the first arm one of whose patterns matches the subject in
+// the local slot subject, and replaces the subject with the value of the arm.
+// The statement form has the value nil.
+func (c *Compiler) compileArms(e *Match, subject int) {
+	var ends []int
+	for _, arm := range e.arms {
+		c.line = arm.keyword.line
+		c.beginScope()
+		names := armNames(arm)
+		for _, name := range names {
+			c.emitOp(OP_NIL)
+			c.addLocal(&Token{lexeme: name, line: arm.keyword.line}, false)
+			c.markInitialized()
+		}
+		next := -1
+		if arm.patterns != nil {
+			base := len(c.locals) - len(names)
+			var matched []int
+			for _, pattern := range arm.patterns {
+				c.emitOpByte(OP_GET_LOCAL, byte(subject))
+				exprs := patternExprs(pattern)
+				for k, expr := range exprs {
+					c.compileOperand(expr, k+1)
+				}
+				c.line = arm.keyword.line
+				c.emitOpShort(OP_MATCH, c.makeConstant(&matchCase{pattern: pattern, exprs: exprs, names: names}))
+				c.emitByte(byte(base))
+				failed := c.emitJump(OP_JUMP_IF_FALSE)
+				c.emitOp(OP_POP)
+				matched = append(matched, c.emitJump(OP_JUMP))
+				c.patchJump(failed)
+				c.emitOp(OP_POP)
+			}
+			// no pattern matched, the variables of the arm are dropped
+			for range names {
+				c.emitOp(OP_POP)
+			}
+			next = c.emitJump(OP_JUMP)
+			for _, jump := range matched {
+				c.patchJump(jump)
+			}
+		}
+		if arm.body != nil {
+			c.compileStmt(arm.body)
+			c.emitOp(OP_NIL)
+		} else {
+			c.compileExpr(arm.value)
+		}
+		c.emitOpByte(OP_SET_LOCAL, byte(subject))
+		c.emitOp(OP_POP)
+		c.endScope()
+		ends = append(ends, c.emitJump(OP_JUMP))
+		if next >= 0 {
+			c.patchJump(next)
+		}
+	}
+	// no arm matched
+	c.emitOp(OP_NIL)
+	c.emitOpByte(OP_SET_LOCAL, byte(subject))
+	c.emitOp(OP_POP)
+	for _, end := range ends {
+		c.patchJump(end)
+	}
+}
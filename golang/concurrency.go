@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LoxTask is the handle returned by spawn, the call runs on its own
+// goroutine with a forked interpreter.
+type LoxTask struct {
+	name   string
+	done   chan struct{}
+	result any
+	// the panic raised by the call, re-raised by join
+	err any
+}
+
+func NewLoxTask(i *Interpreter, function Callable, args []any) *LoxTask {
+	return newTask(i, function.ToString(), func(inter *Interpreter) any {
+		return function.Call(inter, args)
+	})
+}
+
+// newTask runs call on its own goroutine with a fork of i.
+func newTask(i *Interpreter, name string, call func(inter *Interpreter) any) *LoxTask {
+	t := &LoxTask{
+		name: "<task " + name + ">",
+		done: make(chan struct{}),
+	}
+	inter := i.fork(i.env)
+	var untrack func()
+	if i.budget != nil {
+		// the locals of both the task and its spawner are alive meanwhile
+		i.budget.track(i)
+		untrack = i.budget.track(inter)
+	}
+	go func() {
+		if untrack != nil {
+			defer untrack()
+		}
+		defer close(t.done)
+		defer func() {
+			t.err = recover()
+		}()
+		t.result = call(inter)
+	}()
+	return t
+}
+
+func (t *LoxTask) ToString() string {
+	return t.name
+}
+
+func (t *LoxTask) Has(name string) bool {
+	return name == "join" || name == "done"
+}
+
+func (t *LoxTask) Get(name *Token) any {
+	switch name.lexeme {
+	case "join":
+		return NewInterpreterPrimitive(0, func(i *Interpreter, args []any) any {
+			return t.Join(i)
+		})
+	case "done":
+		return NewPrimitive(0, func(args []any) any {
+			select {
+			case <-t.done:
+				return true
+			default:
+				return false
+			}
+		})
+	}
+	Panic(name.line, fmt.Sprintf("Undefined property '%s'.", name.lexeme))
+	return nil
+}
+
+// Join waits for the call to finish and returns its result, unless the run
+// of i stops first.
+func (t *LoxTask) Join(i *Interpreter) any {
+	resume := i.release()
+	select {
+	case <-t.done:
+		resume()
+	case <-i.done():
+		resume()
+		i.abort()
+	}
+	if t.err != nil {
+		panic(t.err)
+	}
+	return t.result
+}
+
+// LoxChannel lets tasks talk to each other. It holds the values sent in a
+// buffer of its own rather than in a go channel, so the memory meter can
+// walk them.
+type LoxChannel struct {
+	capacity int
+	// guards the fields below, it's never held while blocking
+	mu     sync.Mutex
+	closed bool
+	// the values sent and not received yet, a send on an unbuffered channel
+	// waits in it for its value to be received
+	buffer   []any
+	sent     int64
+	received int64
+	// closed and replaced whenever the fields above change, to wake up the
+	// tasks waiting on them
+	changed chan struct{}
+}
+
+func NewLoxChannel(capacity int) *LoxChannel {
+	return &LoxChannel{
+		capacity: capacity,
+		changed:  make(chan struct{}),
+	}
+}
+
+func (c *LoxChannel) ToString() string {
+	return "<channel>"
+}
+
+func (c *LoxChannel) Has(name string) bool {
+	switch name {
+	case "send", "recv", "close", "closed":
+		return true
+	}
+	return false
+}
+
+func (c *LoxChannel) Get(name *Token) any {
+	switch name.lexeme {
+	case "send":
+		return NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+			c.Send(i, args[0])
+			return nil
+		})
+	case "recv":
+		return NewInterpreterPrimitive(0, func(i *Interpreter, args []any) any {
+			return c.Recv(i)
+		})
+	case "close":
+		return NewInterpreterPrimitive(0, func(i *Interpreter, args []any) any {
+			c.Close(i)
+			return nil
+		})
+	case "closed":
+		return NewPrimitive(0, func(args []any) any {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.closed
+		})
+	}
+	Panic(name.line, fmt.Sprintf("Undefined property '%s'.", name.lexeme))
+	return nil
+}
+
+// Send blocks until the value is received or buffered, closing the channel
+// or stopping the run of i meanwhile makes it fail.
+func (c *LoxChannel) Send(i *Interpreter, value any) {
+	// the meter locks the channel, so it's measured before
+	i.allocate(elementSize)
+	// an unbuffered channel holds the value being handed over
+	room := c.capacity
+	if room == 0 {
+		room = 1
+	}
+	c.mu.Lock()
+	for {
+		if c.closed {
+			c.mu.Unlock()
+			Panic(i.line, "Send on closed channel.")
+		}
+		if len(c.buffer) < room {
+			break
+		}
+		c.wait(i)
+	}
+	c.buffer = append(c.buffer, value)
+	c.sent++
+	c.notify()
+	if c.capacity > 0 {
+		c.mu.Unlock()
+		return
+	}
+	for sent := c.sent; c.received < sent; {
+		if c.closed {
+			// nobody took the value, it's still the only one buffered
+			c.buffer = c.buffer[:0]
+			c.mu.Unlock()
+			Panic(i.line, "Send on closed channel.")
+		}
+		c.wait(i)
+	}
+	c.mu.Unlock()
+}
+
+// Recv blocks until a value is available, nil is returned once the channel
+// is closed and drained.
+func (c *LoxChannel) Recv(i *Interpreter) any {
+	c.mu.Lock()
+	for len(c.buffer) == 0 {
+		if c.closed {
+			c.mu.Unlock()
+			return nil
+		}
+		c.wait(i)
+	}
+	v := c.buffer[0]
+	c.buffer[0] = nil
+	c.buffer = c.buffer[1:]
+	c.received++
+	c.notify()
+	c.mu.Unlock()
+	return v
+}
+
+func (c *LoxChannel) Close(i *Interpreter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		Panic(i.line, "Close of closed channel.")
+	}
+	c.closed = true
+	c.notify()
+}
+
+// wait releases mu until the channel changes or the run of i stops, mu is
+// held when it's called.
+func (c *LoxChannel) wait(i *Interpreter) {
+	changed := c.changed
+	c.mu.Unlock()
+	resume := i.release()
+	select {
+	case <-changed:
+		resume()
+	case <-i.done():
+		resume()
+		i.abort()
+	}
+	c.mu.Lock()
+}
+
+// notify wakes up the tasks waiting on the channel, mu is held when it's
+// called.
+func (c *LoxChannel) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
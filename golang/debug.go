@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DisassembleFunction prints the chunk of fn and the ones of the functions
+// declared in it.
+func DisassembleFunction(fn *ObjFunction) {
+	DisassembleChunk(&fn.chunk, fn.ToString())
+	for _, constant := range fn.chunk.constants {
+		if nested, ok := constant.(*ObjFunction); ok {
+			fmt.Println()
+			DisassembleFunction(nested)
+		}
+	}
+}
+
+func DisassembleChunk(chunk *Chunk, name string) {
+	fmt.Printf("== %s ==\n", name)
+	for offset := 0; offset < len(chunk.code); {
+		offset = DisassembleInstruction(chunk, offset)
+	}
+}
+
+// DisassembleInstruction prints the instruction at offset and returns the
+// offset of the next one.
+func DisassembleInstruction(chunk *Chunk, offset int) int {
+	fmt.Printf("%04d ", offset)
+	if offset > 0 && chunk.lines[offset] == chunk.lines[offset-1] {
+		fmt.Print("   | ")
+	} else {
+		fmt.Printf("%4d ", chunk.lines[offset])
+	}
+	op := OpCode(chunk.code[offset])
+	switch op {
+	case OP_CONSTANT, OP_GET_GLOBAL, OP_DEFINE_GLOBAL, OP_DEFINE_CONST, OP_SET_GLOBAL,
+		OP_GET_PROPERTY, OP_SET_PROPERTY, OP_GET_SUPER, OP_CLASS, OP_METHOD, OP_ENUM,
+		OP_DESTRUCTURE, OP_TRAIT, OP_WITH, OP_GET_PRIVATE, OP_SET_PRIVATE:
+		return constantInstruction(op, chunk, offset)
+	case OP_GET_LOCAL, OP_SET_LOCAL, OP_GET_UPVALUE, OP_SET_UPVALUE, OP_CALL, OP_TAIL_CALL, OP_SPAWN:
+		return byteInstruction(op, chunk, offset)
+	case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_NIL:
+		return jumpInstruction(op, 1, chunk, offset)
+	case OP_JUMP_IF_PASSED:
+		slot := chunk.code[offset+1]
+		jump := readShort(chunk, offset+2)
+		fmt.Printf("%-16s %4d %4d -> %d\n", op, slot, offset, offset+4+jump)
+		return offset + 4
+	case OP_MATCH:
+		constant := readShort(chunk, offset+1)
+		fmt.Printf("%-16s %4d '%s' %d\n", op, constant, constantString(chunk.constants[constant]), chunk.code[offset+3])
+		return offset + 4
+	case OP_CALL_KEYWORDS, OP_TAIL_CALL_KEYWORDS, OP_SPAWN_KEYWORDS:
+		constant := readShort(chunk, offset+2)
+		fmt.Printf("%-16s %4d '%s'\n", op, chunk.code[offset+1], constantString(chunk.constants[constant]))
+		return offset + 4
+	case OP_LOOP:
+		return jumpInstruction(op, -1, chunk, offset)
+	case OP_CLOSURE:
+		return closureInstruction(chunk, offset)
+	}
+	if _, ok := opNames[op]; !ok {
+		fmt.Printf("Unknown opcode %d\n", op)
+		return offset + 1
+	}
+	fmt.Println(op)
+	return offset + 1
+}
+
+func readShort(chunk *Chunk, offset int) int {
+	return int(chunk.code[offset])<<8 | int(chunk.code[offset+1])
+}
+
+func constantInstruction(op OpCode, chunk *Chunk, offset int) int {
+	constant := readShort(chunk, offset+1)
+	fmt.Printf("%-16s %4d '%s'\n", op, constant, constantString(chunk.constants[constant]))
+	return offset + 3
+}
+
+// constantString shows a constant, the declarations the VM turns into values
+// at runtime are shown by name.
+func constantString(v any) string {
+	switch c := v.(type) {
+	case *Enum:
+		return c.name.lexeme
+	case *Class:
+		return c.name.lexeme
+	case *Trait:
+		return c.name.lexeme
+	case Pattern:
+		return (&AstPrinter{}).pattern(c)
+	case *matchCase:
+		return (&AstPrinter{}).pattern(c.pattern)
+	case []*Token:
+		names := make([]string, len(c))
+		for k, name := range c {
+			if name != nil {
+				names[k] = name.lexeme
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	return ToString(v)
+}
+
+func byteInstruction(op OpCode, chunk *Chunk, offset int) int {
+	fmt.Printf("%-16s %4d\n", op, chunk.code[offset+1])
+	return offset + 2
+}
+
+func jumpInstruction(op OpCode, sign int, chunk *Chunk, offset int) int {
+	jump := readShort(chunk, offset+1)
+	fmt.Printf("%-16s %4d -> %d\n", op, offset, offset+3+sign*jump)
+	return offset + 3
+}
+
+func closureInstruction(chunk *Chunk, offset int) int {
+	constant := readShort(chunk, offset+1)
+	fn := chunk.constants[constant].(*ObjFunction)
+	fmt.Printf("%-16s %4d %s\n", OP_CLOSURE, constant, fn.ToString())
+	offset += 3
+	for k := 0; k < fn.upvalueCount; k++ {
+		kind := "upvalue"
+		if chunk.code[offset] == 1 {
+			kind = "local"
+		}
+		fmt.Printf("%04d    |                     %s %d\n", offset, kind, chunk.code[offset+1])
+		offset += 2
+	}
+	return offset
+}
+
+// traceStack prints the value stack, it's shown before each instruction in
+// trace mode.
+func (vm *VM) traceStack() {
+	var b strings.Builder
+	b.WriteString("          ")
+	for _, v := range vm.stack[:vm.sp] {
+		b.WriteString("[ ")
+		b.WriteString(Repr(v))
+		b.WriteString(" ]")
+	}
+	fmt.Println(b.String())
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisassembleFunction(t *testing.T) {
+	inter := NewInterpreter()
+	defer inter.Close()
+	fn := Compile(parse(t, inter, "fun add(a, b) { return a + b; }\nprint add(1, 2);\n"), make(map[string]bool), false)
+	want := `== <script> ==
+0000    1 OP_CLOSURE          0 <fn add>
+0003    | OP_DEFINE_GLOBAL    1 'add'
+0006    2 OP_GET_GLOBAL       1 'add'
+0009    | OP_CONSTANT         2 '1'
+0012    | OP_CONSTANT         3 '2'
+0015    | OP_CALL             2
+0017    | OP_PRINT
+0018    | OP_NIL
+0019    | OP_RETURN
+
+== <fn add> ==
+0000    1 OP_GET_LOCAL        1
+0002    | OP_GET_LOCAL        2
+0004    | OP_ADD
+0005    | OP_RETURN
+0006    | OP_NIL
+0007    | OP_RETURN
+`
+	assert.Equal(t, want, captureOutput(t, func() {
+		DisassembleFunction(fn)
+	}))
+}
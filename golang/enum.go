@@ -0,0 +1,106 @@
+package main
+
+import "fmt"
+
+// LoxEnum is the value an enum declaration binds, its members are the only
+// instances of the enum and compare by identity.
+type LoxEnum struct {
+	name    string
+	members []*LoxEnumMember
+	byName  map[string]*LoxEnumMember
+}
+
+type LoxEnumMember struct {
+	enum    *LoxEnum
+	name    string
+	ordinal int
+}
+
+func NewLoxEnum(name string, members []*Token) *LoxEnum {
+	enum := &LoxEnum{
+		name:   name,
+		byName: make(map[string]*LoxEnumMember),
+	}
+	for k, member := range members {
+		m := &LoxEnumMember{
+			enum:    enum,
+			name:    member.lexeme,
+			ordinal: k,
+		}
+		enum.members = append(enum.members, m)
+		enum.byName[m.name] = m
+	}
+	return enum
+}
+
+func (le *LoxEnum) ToString() string {
+	return le.name
+}
+
+func (le *LoxEnum) Has(name string) bool {
+	_, ok := le.byName[name]
+	return ok || name == "values"
+}
+
+func (le *LoxEnum) Get(name *Token) any {
+	if m, ok := le.byName[name.lexeme]; ok {
+		return m
+	}
+	if name.lexeme == "values" {
+		return NewPrimitive(0, func(args []any) any {
+			values := make([]any, len(le.members))
+			for k, m := range le.members {
+				values[k] = m
+			}
+			return NewLoxList(values)
+		})
+	}
+	Panic(name.line, fmt.Sprintf("Undefined member '%s' of enum %s.", name.lexeme, le.name))
+	return nil
+}
+
+func (m *LoxEnumMember) ToString() string {
+	return m.enum.name + "." + m.name
+}
+
+func (m *LoxEnumMember) Has(name string) bool {
+	return name == "name" || name == "ordinal"
+}
+
+func (m *LoxEnumMember) Get(name *Token) any {
+	switch name.lexeme {
+	case "name":
+		return m.name
+	case "ordinal":
+		return float64(m.ordinal)
+	}
+	Panic(name.line, fmt.Sprintf("Undefined property '%s'.", name.lexeme))
+	return nil
+}
+
+// compareMembers orders two members of the same enum by their ordinal, ok is
+// false unless both operands are members and operator is a comparison.
+func compareMembers(operator *Token, left, right any) (ret bool, ok bool) {
+	switch operator.typ {
+	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL:
+	default:
+		return false, false
+	}
+	l, lok := left.(*LoxEnumMember)
+	r, rok := right.(*LoxEnumMember)
+	if !lok || !rok {
+		return false, false
+	}
+	if l.enum != r.enum {
+		Panic(operator.line, fmt.Sprintf("Can't compare members of %s and %s.", l.enum.name, r.enum.name))
+	}
+	switch operator.typ {
+	case GREATER:
+		return l.ordinal > r.ordinal, true
+	case GREATER_EQUAL:
+		return l.ordinal >= r.ordinal, true
+	case LESS:
+		return l.ordinal < r.ordinal, true
+	}
+	return l.ordinal <= r.ordinal, true
+}
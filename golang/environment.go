@@ -1,16 +1,28 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
+// Environment holds the variables of a scope. Globals are looked up by name,
+// locals by the slot the Resolver gave them, which is the order they are
+// declared in.
 type Environment struct {
+	// environments are shared by spawned tasks, so every access is guarded
+	mu sync.RWMutex
+	// the globals, nil for local scopes
 	envs map[string]any
+	// global names defined with const, allocated on first use. constant
+	// locals are rejected by the Resolver.
+	consts map[string]bool
+	values []any
 	// ancestor env
 	enclosing *Environment
 }
 
 func NewEnvironmentWithAncestor(enclosing *Environment) *Environment {
 	return &Environment{
-		envs:      make(map[string]any),
 		enclosing: enclosing,
 	}
 }
@@ -21,28 +33,79 @@ func NewEnvironment() *Environment {
 	}
 }
 
-func (e *Environment) Define(name string, value any) {
+// Define defines a variable and returns its slot, locals must be defined in
+// the order the Resolver declared them.
+func (e *Environment) Define(name string, value any) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.envs == nil {
+		e.values = append(e.values, value)
+		return len(e.values) - 1
+	}
 	e.envs[name] = value
+	delete(e.consts, name)
+	return 0
 }
 
-func (e *Environment) AssignAt(distance int, name *Token, value any) {
-	e.ancestor(distance).envs[name.lexeme] = value
+// DefineConst defines a variable which can't be assigned afterwards.
+func (e *Environment) DefineConst(name string, value any) int {
+	if e.envs == nil {
+		return e.Define(name, value)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.envs[name] = value
+	if e.consts == nil {
+		e.consts = make(map[string]bool)
+	}
+	e.consts[name] = true
+	return 0
 }
 
+// Redefine sets the variable Define returned the slot of.
+func (e *Environment) Redefine(name *Token, slot int, value any) {
+	if e.envs != nil {
+		e.Assign(name, value)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values[slot] = value
+}
+
+func (e *Environment) AssignAt(distance, slot int, value any) {
+	env := e.ancestor(distance)
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.values[slot] = value
+}
+
+func (e *Environment) checkAssignable(name *Token) {
+	if e.consts[name.lexeme] {
+		Panic(name.line, fmt.Sprintf("Can't assign to constant '%s'.", name.lexeme))
+	}
+}
+
+// Assign assigns a global.
 func (e *Environment) Assign(name *Token, value any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	if _, ok := e.envs[name.lexeme]; ok {
+		e.checkAssignable(name)
 		e.envs[name.lexeme] = value
 		return
 	}
-	if e.enclosing != nil {
-		e.enclosing.Assign(name, value)
-		return
-	}
 	Panic(name.line, fmt.Sprintf("Undefined variable '%s'.", name))
 }
 
-func (e *Environment) GetAt(distance int, name string) any {
-	return e.ancestor(distance).envs[name]
+func (e *Environment) GetAt(distance, slot int) any {
+	env := e.ancestor(distance)
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	if slot >= len(env.values) {
+		return nil
+	}
+	return env.values[slot]
 }
 
 func (e *Environment) ancestor(distance int) *Environment {
@@ -53,13 +116,14 @@ func (e *Environment) ancestor(distance int) *Environment {
 	return env
 }
 
+// Get returns a global.
 func (e *Environment) Get(name *Token) any {
-	if val, ok := e.envs[name.lexeme]; ok {
+	e.mu.RLock()
+	val, ok := e.envs[name.lexeme]
+	e.mu.RUnlock()
+	if ok {
 		return val
 	}
-	if e.enclosing != nil {
-		return e.enclosing.Get(name)
-	}
 	Panic(name.line, fmt.Sprintf("Undefined variable '%s'.", name.lexeme))
 	return nil
 }
@@ -19,6 +19,9 @@ type ExprVisitor interface {
 	VisitSetExpr(Expr) any
 	VisitSuperExpr(Expr) any
 	VisitThisExpr(Expr) any
+	VisitSpawnExpr(Expr) any
+	VisitMatchExpr(Expr) any
+	VisitChainExpr(Expr) any
 }
 
 type Binary struct {
@@ -41,6 +44,7 @@ func (e *Grouping) Accept(v ExprVisitor) (ret any) {
 
 type Literal struct {
 	value any
+	line  int
 }
 
 func (e *Literal) Accept(v ExprVisitor) (ret any) {
@@ -87,6 +91,7 @@ type Call struct {
 	callee    Expr
 	paren     *Token
 	arguments []Expr
+	names     []*Token
 }
 
 func (e *Call) Accept(v ExprVisitor) (ret any) {
@@ -94,8 +99,9 @@ func (e *Call) Accept(v ExprVisitor) (ret any) {
 }
 
 type Get struct {
-	object Expr
-	name   *Token
+	object   Expr
+	name     *Token
+	optional bool
 }
 
 func (e *Get) Accept(v ExprVisitor) (ret any) {
@@ -128,3 +134,30 @@ type This struct {
 func (e *This) Accept(v ExprVisitor) (ret any) {
 	return v.VisitThisExpr(e)
 }
+
+type Spawn struct {
+	keyword *Token
+	call    *Call
+}
+
+func (e *Spawn) Accept(v ExprVisitor) (ret any) {
+	return v.VisitSpawnExpr(e)
+}
+
+type Match struct {
+	keyword *Token
+	subject Expr
+	arms    []*MatchArm
+}
+
+func (e *Match) Accept(v ExprVisitor) (ret any) {
+	return v.VisitMatchExpr(e)
+}
+
+type Chain struct {
+	expression Expr
+}
+
+func (e *Chain) Accept(v ExprVisitor) (ret any) {
+	return v.VisitChainExpr(e)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// generatorResult is what the body of a generator hands back to the caller
+// each time it stops running.
+type generatorResult struct {
+	value any
+	done  bool
+	// the panic raised by the body, re-raised on the caller side
+	err any
+}
+
+// LoxGenerator is the iterator returned by calling a function which contains
+// yield.
+type LoxGenerator struct {
+	name string
+	body generatorBody
+	done bool
+	// result fetched by done() but not consumed by next() yet
+	peeked *generatorResult
+	// tasks sharing the generator resume it one at a time, holder is the
+	// interpreter of the run resuming it
+	mu     sync.Mutex
+	holder atomic.Pointer[Interpreter]
+}
+
+// generatorBody runs the body of a generator up to its next yield.
+type generatorBody interface {
+	// resume runs the body within the run of i until it yields or ends
+	resume(i *Interpreter) generatorResult
+	// abandon is called when the generator is dropped while paused
+	abandon()
+}
+
+// generatorState is the body of a generator of the tree walker. It runs in
+// its own goroutine with a forked interpreter, and the two sides hand control
+// to each other through channels so only one of them is running at any time.
+// It doesn't reference the LoxGenerator, so a generator dropped while paused
+// can be collected and its goroutine abandoned.
+type generatorState struct {
+	inter   *Interpreter
+	body    []Stmt
+	env     *Environment
+	started bool
+	resumes chan struct{}
+	results chan generatorResult
+	// closed when the generator is dropped before its body finished
+	abandoned chan struct{}
+}
+
+// generatorAbandoned unwinds the body of an abandoned generator.
+type generatorAbandoned struct{}
+
+func NewLoxGenerator(i *Interpreter, fn *Function, env *Environment) *LoxGenerator {
+	state := &generatorState{
+		body:      fn.body,
+		env:       env,
+		resumes:   make(chan struct{}),
+		results:   make(chan generatorResult),
+		abandoned: make(chan struct{}),
+	}
+	state.inter = i.fork(env)
+	state.inter.generator = state
+	return newGenerator(fn.name.lexeme, state)
+}
+
+func newGenerator(name string, body generatorBody) *LoxGenerator {
+	g := &LoxGenerator{name: name, body: body}
+	runtime.SetFinalizer(g, (*LoxGenerator).abandon)
+	return g
+}
+
+func (g *LoxGenerator) abandon() {
+	if !g.done {
+		g.body.abandon()
+	}
+}
+
+func (g *LoxGenerator) ToString() string {
+	return "<generator " + g.name + ">"
+}
+
+func (g *LoxGenerator) Has(name string) bool {
+	return name == "next" || name == "done"
+}
+
+func (g *LoxGenerator) Get(name *Token) any {
+	switch name.lexeme {
+	case "next":
+		return NewInterpreterPrimitive(0, func(i *Interpreter, args []any) any {
+			return g.Next(i)
+		})
+	case "done":
+		return NewInterpreterPrimitive(0, func(i *Interpreter, args []any) any {
+			return g.Done(i)
+		})
+	}
+	Panic(name.line, fmt.Sprintf("Undefined property '%s'.", name.lexeme))
+	return nil
+}
+
+// Next resumes the body until the next yield and returns the yielded value,
+// nil is returned once the body has finished.
+func (g *LoxGenerator) Next(i *Interpreter) any {
+	if !g.lock(i) {
+		return nil
+	}
+	defer g.unlock()
+	if g.peeked != nil {
+		res := g.peeked
+		g.peeked = nil
+		return res.value
+	}
+	return g.step(i).value
+}
+
+// Done reports whether the body has finished, which may require running it
+// up to the next yield.
+func (g *LoxGenerator) Done(i *Interpreter) bool {
+	if !g.lock(i) {
+		return true
+	}
+	defer g.unlock()
+	if g.peeked != nil {
+		return false
+	}
+	if g.done {
+		return true
+	}
+	res := g.step(i)
+	if res.done {
+		return true
+	}
+	g.peeked = &res
+	return false
+}
+
+// lock waits for the other tasks resuming the generator, it reports false
+// when the run of i is the one resuming it, from within the body.
+func (g *LoxGenerator) lock(i *Interpreter) bool {
+	for x := i; x != nil; x = x.caller {
+		if g.holder.Load() == x {
+			return false
+		}
+	}
+	resume := i.release()
+	g.mu.Lock()
+	resume()
+	g.holder.Store(i)
+	return true
+}
+
+func (g *LoxGenerator) unlock() {
+	g.holder.Store(nil)
+	g.mu.Unlock()
+}
+
+// step runs the body until it yields or ends, within the run of i which
+// resumes it. The generator is locked by i.
+func (g *LoxGenerator) step(i *Interpreter) generatorResult {
+	if g.done {
+		return generatorResult{done: true}
+	}
+	// a body failing finds it done
+	g.done = true
+	res := g.body.resume(i)
+	g.done = res.done
+	if res.err != nil {
+		panic(res.err)
+	}
+	return res
+}
+
+func (s *generatorState) resume(i *Interpreter) generatorResult {
+	// the body takes its steps from the budget of whoever resumes it, whose
+	// locals stay alive meanwhile. The channels below order this with the
+	// reads of the body.
+	s.inter.budget = i.budget
+	s.inter.caller = i
+	if !s.started {
+		s.started = true
+		go s.run()
+	} else {
+		select {
+		case s.resumes <- struct{}{}:
+		case <-s.inter.closed:
+			panic("Generator used after the interpreter was closed.")
+		}
+	}
+	select {
+	case res := <-s.results:
+		return res
+	case <-s.inter.closed:
+		panic("Generator used after the interpreter was closed.")
+	}
+}
+
+// abandon ends the goroutine of a generator paused at a yield.
+func (s *generatorState) abandon() {
+	if s.started {
+		close(s.abandoned)
+	}
+}
+
+func (s *generatorState) run() {
+	defer func() {
+		err := recover()
+		if _, ok := err.(generatorAbandoned); ok {
+			return
+		}
+		select {
+		case s.results <- generatorResult{done: true, err: err}:
+		case <-s.inter.closed:
+		}
+	}()
+	// a bare return ends the generator like falling off the body
+	s.inter.executeBlock(s.body, s.env)
+}
+
+// yield is called from the body goroutine, it hands the value to the caller
+// and blocks until the body is resumed, or abandoned.
+func (s *generatorState) yield(value any) {
+	select {
+	case s.results <- generatorResult{value: value}:
+	case <-s.inter.closed:
+		panic(generatorAbandoned{})
+	}
+	select {
+	case <-s.resumes:
+	case <-s.abandoned:
+		panic(generatorAbandoned{})
+	case <-s.inter.closed:
+		panic(generatorAbandoned{})
+	}
+}
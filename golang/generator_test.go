@@ -0,0 +1,62 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const endless = `
+fun naturals() {
+  var n = 0;
+  while (true) {
+    yield n;
+    n = n + 1;
+  }
+}
+`
+
+// bodiesExited waits for the goroutines running generator bodies to exit.
+func bodiesExited() bool {
+	buf := make([]byte, 1<<20)
+	for k := 0; k < 100; k++ {
+		runtime.GC()
+		n := runtime.Stack(buf, true)
+		if !strings.Contains(string(buf[:n]), "(*generatorState).run") {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestDroppedGeneratorsExit(t *testing.T) {
+	inter := NewInterpreter()
+	defer inter.Close()
+	parser := &Parser{tokens: NewScanner(endless + `
+fun take() {
+  var g = naturals();
+  g.next();
+  return g.next();
+}
+for (var k = 0; k < 50; k = k + 1) take();
+`).ScanTokens()}
+	stmts := parser.ParseStmts()
+	NewResolver(inter).Resolve(stmts)
+	inter.Execute(stmts)
+	assert.False(t, hadError)
+	assert.True(t, bodiesExited(), "the paused generators weren't abandoned")
+}
+
+func TestCloseAbandonsGenerators(t *testing.T) {
+	out := runScript(t, endless+`
+var g = naturals();
+g.next();
+print g.next();
+`, false)
+	assert.Equal(t, "1\n", out)
+	assert.True(t, bodiesExited(), "Close didn't abandon the generator")
+}
@@ -2,77 +2,373 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"math/big"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var inter = NewInterpreter()
 
+// vm runs the scripts instead of the tree walker when --vm is given.
+var vm *VM
+
+// optimize runs the Optimizer on the scripts, --no-optimize turns it off.
+var optimize = true
+
+// options are the limits given by --max-steps, --timeout and --max-allocs.
+var options Options
+
+// capabilities are the builtin groups --allow grants, in --fs-root if given.
+var capabilities = AllCapabilities()
+
 func main() {
-	if len(os.Args) > 2 {
-		fmt.Println("Usage: glox [script]")
+	args := os.Args[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		switch args[0] {
+		case "--vm", "--trace":
+			if vm == nil {
+				vm = NewVM(inter)
+			}
+			// tracing implies the VM
+			if args[0] == "--trace" {
+				vm.trace = true
+			}
+		case "--no-optimize":
+			optimize = false
+		default:
+			if !parseOption(args[0]) {
+				return
+			}
+		}
+		args = args[1:]
+	}
+	inter.SetCapabilities(capabilities)
+	var exit *ExitError
+	if len(args) == 2 && args[0] == "check" {
+		checkFile(args[1])
+	} else if len(args) == 2 && args[0] == "disasm" {
+		disasmFile(args[1])
+	} else if len(args) == 2 && args[0] == "ast" {
+		astFile(args[1])
+	} else if len(args) > 1 {
+		fmt.Println("Usage: glox [--vm] [--trace] [--no-optimize] [--max-depth=N] [--max-steps=N] [--timeout=D] [--max-allocs=N] [--max-memory=BYTES] [--allow=GROUPS] [--fs-root=DIR] [check|disasm|ast] [script]")
 		return
-	} else if len(os.Args) == 2 {
-		runFile(os.Args[1])
+	} else if len(args) == 1 {
+		exit = runFile(args[0])
 	} else {
-		runPrompt()
+		exit = runPrompt()
+	}
+	if exit != nil {
+		os.Exit(exit.Code)
+	}
+}
+
+// parseOption sets the option of one of the limit or capability flags, it
+// reports whether the flag is valid.
+func parseOption(arg string) bool {
+	name, value, _ := strings.Cut(arg, "=")
+	switch name {
+	case "--max-depth":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			fmt.Printf("Invalid call depth %s\n", value)
+			return false
+		}
+		inter.SetMaxCallDepth(n)
+		return true
+	case "--timeout":
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			fmt.Printf("Invalid timeout %s\n", value)
+			return false
+		}
+		options.Timeout = d
+		return true
+	case "--max-memory":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			fmt.Printf("Invalid memory quota %s\n", value)
+			return false
+		}
+		options.MaxMemory = n
+		return true
+	case "--max-steps", "--max-allocs":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			fmt.Printf("Invalid limit %s\n", value)
+			return false
+		}
+		if name == "--max-steps" {
+			options.MaxSteps = n
+		} else {
+			options.MaxAllocations = n
+		}
+		return true
+	case "--allow":
+		// only the groups listed are granted
+		caps := Capabilities{FSRoot: capabilities.FSRoot}
+		for _, group := range strings.Split(value, ",") {
+			switch group {
+			case "fs":
+				caps.FS = true
+			case "env":
+				caps.Env = true
+			case "time":
+				caps.Time = true
+			case "process":
+				caps.Process = true
+			case "":
+			default:
+				fmt.Printf("Unknown capability %s\n", group)
+				return false
+			}
+		}
+		capabilities = caps
+		return true
+	case "--fs-root":
+		if value == "" {
+			fmt.Println("Invalid fs root")
+			return false
+		}
+		capabilities.FSRoot = value
+		return true
+	}
+	fmt.Printf("Unknown option %s\n", arg)
+	return false
+}
+
+func runFile(file string) *ExitError {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
 	}
+	return run(string(content), false)
 }
 
-func runFile(file string) {
+// checkFile reports the static errors of a script without running it, the
+// exit status is 1 when there are any.
+func checkFile(file string) {
 	content, err := os.ReadFile(file)
 	if err != nil {
 		panic(err)
 	}
-	run(string(content))
+	scanner := NewScanner(string(content))
+	parser := &Parser{tokens: scanner.ScanTokens()}
+	stmts := parser.ParseStmts()
+	if !hadError && stmts != nil {
+		NewResolver(inter).Resolve(stmts)
+	}
+	if hadError {
+		os.Exit(1)
+	}
+	errors := NewTypeChecker(inter).Check(stmts)
+	for _, msg := range errors {
+		fmt.Println(msg)
+	}
+	if len(errors) != 0 {
+		os.Exit(1)
+	}
+}
+
+// disasmFile prints the bytecode the VM runs for a script.
+func disasmFile(file string) {
+	stmts := prepareFile(file)
+	fn := Compile(stmts, make(map[string]bool), false)
+	if fn == nil {
+		os.Exit(1)
+	}
+	DisassembleFunction(fn)
+}
 
+// astFile prints the syntax tree of a script as it is run, after the
+// optimizer rewrote it.
+func astFile(file string) {
+	fmt.Print((&AstPrinter{}).Print(prepareFile(file)))
 }
 
-func runPrompt() {
+// prepareFile parses, resolves and optimizes a script, exiting on errors.
+func prepareFile(file string) []Stmt {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	scanner := NewScanner(string(content))
+	parser := &Parser{tokens: scanner.ScanTokens()}
+	stmts := parser.ParseStmts()
+	if !hadError && stmts != nil {
+		NewResolver(inter).Resolve(stmts)
+	}
+	if hadError {
+		os.Exit(1)
+	}
+	if optimize {
+		stmts = NewOptimizer(inter).Optimize(stmts)
+	}
+	return stmts
+}
+
+func runPrompt() *ExitError {
+	// a line may join the tasks an earlier one spawned
+	options.KeepTasks = true
+	defer inter.Close()
+	scanner := bufio.NewScanner(os.Stdin)
 	for {
-		scanner := bufio.NewScanner(os.Stdin)
 		fmt.Print(">")
-		scanner.Scan()
+		if !scanner.Scan() {
+			return nil
+		}
 		text := scanner.Text()
 		if text == "exit" || text == "q" {
-			break
+			return nil
+		}
+		hadError = false
+		if exit := run(text, true); exit != nil {
+			return exit
 		}
-		run(text)
 	}
 }
 
-func run(content string) {
+// interruptible returns a context canceled when glox is interrupted before
+// stop is called. Unlike with signal.NotifyContext, stop doesn't cancel it:
+// the tasks a REPL line spawned run on after it.
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-stopped:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(interrupts)
+		close(stopped)
+	}
+}
+
+// run executes the source, with echo the value of a trailing expression
+// statement is printed like the REPL does. It returns the ExitError of a
+// script calling exit.
+func run(content string, echo bool) *ExitError {
 	scanner := NewScanner(content)
 	parser := &Parser{tokens: scanner.ScanTokens()}
 	stmts := parser.ParseStmts()
 	if hadError {
-		return
+		return nil
 	}
 	if stmts == nil {
-		return
+		return nil
 	}
 	resolver := NewResolver(inter)
 	resolver.Resolve(stmts)
 	if hadError {
-		return
+		return nil
+	}
+	if optimize {
+		stmts = NewOptimizer(inter).Optimize(stmts)
+		if len(stmts) == 0 {
+			return nil
+		}
 	}
-	inter.Execute(stmts)
+	// interrupting aborts the script instead of the interpreter
+	ctx, stop := interruptible()
+	defer stop()
+	if vm != nil {
+		return runVM(ctx, stmts, echo)
+	}
+	val, err := inter.ExecuteContext(ctx, stmts, options)
+	if err != nil {
+		return runError(err)
+	}
+	if _, ok := stmts[len(stmts)-1].(*Expression); ok && echo && !hadError {
+		fmt.Println(inter.repr(val))
+	}
+	return nil
+}
+
+func runVM(ctx context.Context, stmts []Stmt, echo bool) *ExitError {
+	fn := Compile(stmts, vm.globalConsts, echo)
+	if fn == nil {
+		return nil
+	}
+	val, err := vm.InterpretContext(ctx, fn, options)
+	if err != nil {
+		return runError(err)
+	}
+	if _, ok := stmts[len(stmts)-1].(*Expression); ok && echo && !hadError {
+		if str, ok := val.(string); ok {
+			fmt.Println(Repr(str))
+		} else {
+			fmt.Println(vm.stringify(val))
+		}
+	}
+	return nil
+}
+
+// runError prints the error of a run, unless the script called exit, whose
+// ExitError it returns.
+func runError(err error) *ExitError {
+	if exit, ok := err.(*ExitError); ok {
+		return exit
+	}
+	printError(err)
+	hadError = true
+	return nil
 }
 
+// ToString converts a value to its plain string form, strings are left
+// unquoted.
 func ToString(in any) string {
 	if in == nil {
 		return "nil"
 	}
 	switch in.(type) {
 	case string:
-		return fmt.Sprintf(`"%s"`, in)
+		return in.(string)
 	case int:
 		return fmt.Sprintf("%d", in)
+	case *big.Int:
+		return in.(*big.Int).String()
+	case *Decimal:
+		return in.(*Decimal).String()
 	case Callable:
 		return in.(Callable).ToString()
-	case *LoxInstance:
-		return in.(*LoxInstance).ToString()
+	case Object:
+		return in.(Object).ToString()
+	case *LoxTrait:
+		return in.(*LoxTrait).ToString()
+	case *ObjClosure:
+		return in.(*ObjClosure).ToString()
+	case *ObjClass:
+		return in.(*ObjClass).ToString()
+	case *ObjTrait:
+		return in.(*ObjTrait).ToString()
+	case *ObjBoundMethod:
+		return in.(*ObjBoundMethod).ToString()
+	case *ObjFunction:
+		return in.(*ObjFunction).ToString()
 	default:
 		return fmt.Sprintf("%v", in)
 	}
 }
+
+// Repr is like ToString but quotes strings.
+func Repr(in any) string {
+	switch v := in.(type) {
+	case string:
+		return fmt.Sprintf(`"%s"`, v)
+	case *big.Int:
+		return v.String() + "n"
+	case *Decimal:
+		return "Decimal(\"" + v.String() + "\")"
+	}
+	return ToString(in)
+}
@@ -1,10 +1,28 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Object is implemented by every value exposing properties through '.'.
+type Object interface {
+	Get(name *Token) any
+	// Has reports whether Get finds the property
+	Has(name string) bool
+	ToString() string
+}
 
 type LoxInstance struct {
 	loxClass *LoxClass
-	fileds   map[string]any
+	// instances are shared by spawned tasks, so fields access is guarded
+	mu     sync.RWMutex
+	fileds map[string]any
+	// '#name' fields, each class of the hierarchy has its own ones
+	private map[*LoxClass]map[string]any
+	// a frozen instance rejects any further Set
+	frozen bool
 }
 
 func NewLoxInstance(loxClass *LoxClass) *LoxInstance {
@@ -19,11 +37,92 @@ func (lox *LoxInstance) ToString() string {
 }
 
 func (lox *LoxInstance) Set(name *Token, value any) {
+	lox.mu.Lock()
+	defer lox.mu.Unlock()
+	if lox.frozen {
+		Panic(name.line, fmt.Sprintf("Can't set property '%s' on a frozen instance.", name.lexeme))
+	}
 	lox.fileds[name.lexeme] = value
 }
 
+// GetPrivate returns the private field or method named name declared by
+// owner, private members of the superclasses aren't visible.
+func (lox *LoxInstance) GetPrivate(owner *LoxClass, name *Token) any {
+	lox.mu.RLock()
+	val, ok := lox.private[owner][name.lexeme]
+	lox.mu.RUnlock()
+	if ok {
+		return val
+	}
+	if method, ok := owner.methods[name.lexeme]; ok {
+		return method.Bind(lox)
+	}
+	Panic(name.line, fmt.Sprintf("Undefined private property '%s' of class %s.", name.lexeme, owner.name))
+	return nil
+}
+
+func (lox *LoxInstance) SetPrivate(owner *LoxClass, name *Token, value any) {
+	lox.mu.Lock()
+	defer lox.mu.Unlock()
+	if lox.frozen {
+		Panic(name.line, fmt.Sprintf("Can't set property '%s' on a frozen instance.", name.lexeme))
+	}
+	if lox.private == nil {
+		lox.private = make(map[*LoxClass]map[string]any)
+	}
+	if lox.private[owner] == nil {
+		lox.private[owner] = make(map[string]any)
+	}
+	lox.private[owner][name.lexeme] = value
+}
+
+func (lox *LoxInstance) ClassName() string {
+	return lox.loxClass.name
+}
+
+func (lox *LoxInstance) MethodNames() []string {
+	return lox.loxClass.MethodNames()
+}
+
+// Field returns the value of a field, methods aren't looked up.
+func (lox *LoxInstance) Field(name string) (any, bool) {
+	lox.mu.RLock()
+	defer lox.mu.RUnlock()
+	val, ok := lox.fileds[name]
+	return val, ok
+}
+
+// FieldNames returns the names of the public fields, sorted.
+func (lox *LoxInstance) FieldNames() []string {
+	lox.mu.RLock()
+	defer lox.mu.RUnlock()
+	names := make([]string, 0, len(lox.fileds))
+	for name := range lox.fileds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Freeze makes every field of the instance read only.
+func (lox *LoxInstance) Freeze() {
+	lox.mu.Lock()
+	defer lox.mu.Unlock()
+	lox.frozen = true
+}
+
+func (lox *LoxInstance) Has(name string) bool {
+	if _, ok := lox.Field(name); ok {
+		return true
+	}
+	return lox.loxClass.FindMethod(&Token{typ: IDENTIFIER, lexeme: name}) != nil
+}
+
 func (lox *LoxInstance) Get(name *Token) any {
-	if val, ok := lox.fileds[name.lexeme]; ok {
+	lox.mu.RLock()
+	val, ok := lox.fileds[name.lexeme]
+	lox.mu.RUnlock()
+	if ok {
 		return val
 	}
 
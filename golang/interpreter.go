@@ -1,23 +1,96 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+)
 
 type Interpreter struct {
 	line    int
 	env     *Environment
 	globals *Environment
-	locals  map[Expr]int
+	locals  map[Expr]localSlot
+	// the generator whose body this interpreter is running, if any
+	generator *generatorState
+	// the calls being run, the innermost last
+	frames       []callFrame
+	maxCallDepth int
+	// the limits of the run, nil outside of ExecuteContext
+	budget *budget
+	// the groups of host builtins the scripts may call
+	capabilities Capabilities
+	// what the last run allocated
+	memoryStats MemoryStats
+	// the interpreter resuming the generator this one runs, whose locals
+	// are alive too
+	caller *Interpreter
+	// the VM running on this interpreter, its stack holds values too
+	vm *VM
+	// set while tasks run alongside this interpreter under a memory quota,
+	// it guards env and frames which the meter reads from other goroutines
+	roots *roots
+	// held by the goroutine running the VM, set while a VM runs
+	gil *sync.Mutex
+	// closed by Close, shared with the forks
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// defaultMaxCallDepth keeps deep recursion well within the Go stack.
+const defaultMaxCallDepth = 10000
+
+// callFrame is a call in progress, shown by the stack overflow error.
+type callFrame struct {
+	function Callable
+	line     int
+	// the environment of the caller, its locals are alive during the call
+	env *Environment
+}
+
+// localSlot locates a local variable, the environment distance ancestors
+// away holds it at index slot.
+type localSlot struct {
+	distance int
+	slot     int
 }
 
 func NewInterpreter() *Interpreter {
 	i := &Interpreter{
-		globals: NewEnvironment(),
-		locals:  make(map[Expr]int),
+		globals:      NewEnvironment(),
+		locals:       make(map[Expr]localSlot),
+		maxCallDepth: defaultMaxCallDepth,
+		capabilities: DefaultCapabilities(),
+		closed:       make(chan struct{}),
+		closeOnce:    &sync.Once{},
 	}
 	i.env = i.globals
 	return injectPrimitives(i)
 }
 
+// Close abandons the generators the scripts left paused so their goroutines
+// exit, hosts call it once they are done with the interpreter.
+func (i *Interpreter) Close() {
+	i.closeOnce.Do(func() {
+		close(i.closed)
+	})
+}
+
+// fork returns an interpreter sharing the globals and resolved locals of i
+// but executing in its own environment, so it can run on another goroutine.
+func (i *Interpreter) fork(env *Environment) *Interpreter {
+	f := *i
+	f.env = env
+	f.generator = nil
+	f.frames = nil
+	f.caller = nil
+	f.vm = nil
+	f.roots = nil
+	return &f
+}
+
 func (i *Interpreter) isTruthy(val any) bool {
 	switch e := val.(type) {
 	case bool:
@@ -35,10 +108,21 @@ func (i *Interpreter) VisitUnaryExpr(expr Expr) any {
 	}
 	i.line = e.operator.line
 	right := i.evaluate(e.right)
+	if e.operator.typ == MINUS {
+		if ret, ok := i.callSpecial(right, "__neg__", e.operator); ok {
+			return ret
+		}
+	}
 	switch e.operator.typ {
 	case BANG:
 		return !i.isTruthy(right)
 	case MINUS:
+		switch n := right.(type) {
+		case *big.Int:
+			return i.allocateNumber(new(big.Int).Neg(n))
+		case *Decimal:
+			return i.allocateNumber(n.Neg())
+		}
 		return -i.float64Val(right)
 	default:
 		Panic(e.operator.line, fmt.Sprintf("Expect unary operator but got %v", e.operator.lexeme))
@@ -62,21 +146,47 @@ func (i *Interpreter) VisitBinaryExpr(expr Expr) any {
 	i.line = e.operator.line
 	left := i.evaluate(e.left)
 	right := i.evaluate(e.right)
+	if name, ok := operatorMethods[e.operator.typ]; ok {
+		if ret, ok := i.callSpecial(left, name, e.operator, right); ok {
+			return ret
+		}
+	}
+	if ret, ok := compareMembers(e.operator, left, right); ok {
+		return ret
+	}
+	if _, concat := left.(string); !concat && (isBigNumber(left) || isBigNumber(right)) {
+		switch e.operator.typ {
+		case MINUS, SLASH, STAR, PLUS, GREATER, GREATER_EQUAL, LESS, LESS_EQUAL:
+			return i.allocateNumber(bigArithmetic(e.operator, left, right))
+		}
+	}
 	switch e.operator.typ {
 	case MINUS:
-		return i.float64Val(left) - i.float64Val(right)
+		l, r := i.float64Val(left), i.float64Val(right)
+		return i.allocateNumber(promoteOverflow(e.operator, l, r, l-r))
 	case SLASH:
 		return i.float64Val(left) / i.float64Val(right)
 	case STAR:
-		return i.float64Val(left) * i.float64Val(right)
+		l, r := i.float64Val(left), i.float64Val(right)
+		return i.allocateNumber(promoteOverflow(e.operator, l, r, l*r))
 	case PLUS:
 		switch l := left.(type) {
 		case string:
-			return l + fmt.Sprintf("%v", right)
+			s := l + i.stringify(right)
+			i.allocate(stringSize + len(s))
+			return s
+		case *LoxInstance:
+			if r, ok := right.(string); ok {
+				s := i.stringify(l) + r
+				i.allocate(stringSize + len(s))
+				return s
+			}
+			Panic(e.operator.line, fmt.Sprintf("Expect string or float type but got %v", ToString(l)))
 		case float64:
-			return l + i.float64Val(right)
+			r := i.float64Val(right)
+			return i.allocateNumber(promoteOverflow(e.operator, l, r, l+r))
 		default:
-			Panic(e.operator.line, fmt.Sprintf("Expect string or float type but got %v", l))
+			Panic(e.operator.line, fmt.Sprintf("Expect string or float type but got %v", ToString(l)))
 		}
 	case GREATER:
 		return i.float64Val(left) > i.float64Val(right)
@@ -86,6 +196,8 @@ func (i *Interpreter) VisitBinaryExpr(expr Expr) any {
 		return i.float64Val(left) < i.float64Val(right)
 	case LESS_EQUAL:
 		return i.float64Val(left) <= i.float64Val(right)
+	case INSTANCEOF:
+		return i.isInstance(left, right, e.operator)
 	case BANG_EQUAL:
 		return !i.isEqual(left, right)
 	case EQUAL_EQUAL:
@@ -96,13 +208,80 @@ func (i *Interpreter) VisitBinaryExpr(expr Expr) any {
 	return nil
 }
 
+// operatorMethods maps the binary operators a class can overload to the
+// method implementing them, == and != go through isEqual.
+var operatorMethods = map[TokenType]string{
+	PLUS:          "__add__",
+	MINUS:         "__sub__",
+	STAR:          "__mul__",
+	SLASH:         "__div__",
+	LESS:          "__lt__",
+	LESS_EQUAL:    "__le__",
+	GREATER:       "__gt__",
+	GREATER_EQUAL: "__ge__",
+}
+
+// callSpecial calls a special method such as an operator overload or
+// toString when the operand is an instance whose class defines it.
+func (i *Interpreter) callSpecial(operand any, name string, operator *Token, args ...any) (any, bool) {
+	instance, ok := operand.(*LoxInstance)
+	if !ok {
+		return nil, false
+	}
+	method, ok := instance.loxClass.FindMethod(&Token{typ: IDENTIFIER, lexeme: name, line: operator.line}).(Callable)
+	if !ok {
+		return nil, false
+	}
+	if msg := arityMessage(method, len(args)); msg != "" {
+		Panic(operator.line, fmt.Sprintf("Operator method '%s': %s", name, msg))
+	}
+	return i.call(method.Bind(instance), args, operator.line), true
+}
+
+// stringify converts a value to the string print shows, instances of classes
+// defining toString are converted by calling it.
+func (i *Interpreter) stringify(v any) string {
+	switch val := v.(type) {
+	case *LoxInstance:
+		if ret, ok := i.callSpecial(val, "toString", &Token{line: i.line}); ok {
+			str, ok := ret.(string)
+			if !ok {
+				Panic(i.line, fmt.Sprintf("toString must return a string but got %v", Repr(ret)))
+			}
+			return str
+		}
+	case *LoxList:
+		return (&listPrinter{element: i.repr}).print(val)
+	}
+	return ToString(v)
+}
+
+// repr is like stringify but quotes strings, it's used by the REPL echo and
+// for the elements of collections.
+func (i *Interpreter) repr(v any) string {
+	switch v.(type) {
+	case string, *big.Int, *Decimal:
+		return Repr(v)
+	}
+	return i.stringify(v)
+}
+
 func (i *Interpreter) isEqual(a, b any) bool {
+	if l, r, ok := floats(a, b); ok {
+		return l == r
+	}
 	if a == nil && b == nil {
 		return true
 	}
 	if a == nil {
 		return false
 	}
+	if ret, ok := i.callSpecial(a, "__eq__", &Token{line: i.line}, b); ok {
+		return i.isTruthy(ret)
+	}
+	if isNumber(a) && isNumber(b) {
+		return numbersEqual(a, b)
+	}
 	return a == b
 }
 
@@ -131,8 +310,8 @@ func (i *Interpreter) VisitVariableExpr(expr Expr) any {
 }
 
 func (i *Interpreter) lookupVariable(name *Token, expr Expr) any {
-	if distance, ok := i.locals[expr]; ok {
-		return i.env.GetAt(distance, name.lexeme)
+	if local, ok := i.locals[expr]; ok {
+		return i.env.GetAt(local.distance, local.slot)
 	}
 	return i.globals.Get(name)
 }
@@ -142,20 +321,182 @@ func (i *Interpreter) VisitCallExpr(expr Expr) any {
 	if !ok {
 		panic("should be call type expr")
 	}
+	function, args := i.evaluateCall(e)
+	if function == nil {
+		return shortCircuit{}
+	}
+	return i.call(function, args, e.paren.line)
+}
+
+// SetMaxCallDepth sets how many calls can be nested before a stack overflow
+// error is raised, tail calls don't count.
+func (i *Interpreter) SetMaxCallDepth(depth int) {
+	i.maxCallDepth = depth
+}
 
+// call runs a call made on line, keeping track of the call depth.
+func (i *Interpreter) call(function Callable, args []any, line int) any {
+	if len(i.frames) >= i.maxCallDepth {
+		Panic(line, "Stack overflow"+i.stackTrace())
+	}
+	i.pushFrame(callFrame{function: function, line: line, env: i.env})
+	ret := function.Call(i, args)
+	i.popFrame()
+	return ret
+}
+
+func (i *Interpreter) pushFrame(frame callFrame) {
+	if i.roots != nil {
+		i.roots.mu.Lock()
+		defer i.roots.mu.Unlock()
+	}
+	i.frames = append(i.frames, frame)
+}
+
+func (i *Interpreter) popFrame() {
+	if i.roots != nil {
+		i.roots.mu.Lock()
+		defer i.roots.mu.Unlock()
+	}
+	// the popped frame mustn't keep the function alive, it may hold a
+	// generator which can only be abandoned once collected
+	i.frames[len(i.frames)-1] = callFrame{}
+	i.frames = i.frames[:len(i.frames)-1]
+}
+
+// stackTrace lists the innermost calls in progress.
+func (i *Interpreter) stackTrace() string {
+	calls := make([]traceCall, len(i.frames))
+	for k, frame := range i.frames {
+		calls[k] = traceCall{name: frame.function.ToString(), line: frame.line}
+	}
+	return formatTrace(calls)
+}
+
+// traceCall is a call shown by the stack overflow error.
+type traceCall struct {
+	name string
+	line int
+}
+
+// formatTrace lists the innermost of calls, repeated calls like the ones of
+// a recursion are shown once.
+func formatTrace(calls []traceCall) string {
+	const shown = 10
+	var b strings.Builder
+	lines := 0
+	for k := len(calls) - 1; k >= 0; {
+		call := calls[k]
+		n := 1
+		for k-n >= 0 && calls[k-n] == call {
+			n++
+		}
+		k -= n
+		if lines == shown {
+			b.WriteString(fmt.Sprintf("\n    ... %v more", k+n+1))
+			break
+		}
+		lines++
+		b.WriteString(fmt.Sprintf("\n    in %s called at line %v", call.name, call.line))
+		if n > 1 {
+			b.WriteString(fmt.Sprintf(" (%v times)", n))
+		}
+	}
+	return b.String()
+}
+
+// shortCircuit is what the hops after a '?.' applied to nil evaluate to, the
+// enclosing Chain turns it into nil.
+type shortCircuit struct{}
+
+func (i *Interpreter) VisitChainExpr(expr Expr) any {
+	e, ok := expr.(*Chain)
+	if !ok {
+		panic("should be chain type expr")
+	}
+	val := i.evaluate(e.expression)
+	if _, ok := val.(shortCircuit); ok {
+		return nil
+	}
+	return val
+}
+
+// evaluateCall evaluates the callee and the arguments of a call and checks
+// they fit together. The function is nil when a '?.' short-circuited the
+// callee, the arguments aren't evaluated then.
+func (i *Interpreter) evaluateCall(e *Call) (Callable, []any) {
 	callee := i.evaluate(e.callee)
+	if _, ok := callee.(shortCircuit); ok {
+		return nil, nil
+	}
 	args := make([]any, len(e.arguments))
 	for k, v := range e.arguments {
 		args[k] = i.evaluate(v)
 	}
+	i.line = e.paren.line
 	function, ok := callee.(Callable)
 	if !ok {
 		Panic(e.paren.line, fmt.Sprintf("Expect callable but got %v", callee))
 	}
-	if len(args) != function.Arity() {
-		Panic(e.paren.line, fmt.Sprintf("Expected %v arguments but got %v", function.Arity(), len(args)))
+	if e.names != nil {
+		var params []*Token
+		if kc, ok := function.(keywordCallable); ok {
+			params = kc.Params()
+		}
+		min, _ := function.Arity()
+		args = bindKeywordArgs(params, min, e.names, args, e.paren.line)
+	}
+	if msg := arityMessage(function, len(args)); msg != "" {
+		Panic(e.paren.line, msg)
 	}
-	return function.Call(i, args)
+	return function, args
+}
+
+// bindKeywordArgs moves the keyword arguments to the position of the
+// parameter they name, names holds the name of each argument and nil for the
+// positional ones. The first min parameters are required.
+func bindKeywordArgs(params []*Token, min int, names []*Token, values []any, line int) []any {
+	args := make([]any, 0, len(values))
+	for k, v := range values {
+		name := names[k]
+		if name == nil {
+			args = append(args, v)
+			continue
+		}
+		idx := -1
+		for j, param := range params {
+			if param.lexeme == name.lexeme {
+				idx = j
+				break
+			}
+		}
+		if idx < 0 {
+			Panic(name.line, fmt.Sprintf("Unknown parameter '%s'.", name.lexeme))
+		}
+		for len(args) <= idx {
+			args = append(args, missingArgument{})
+		}
+		if _, ok := args[idx].(missingArgument); !ok {
+			Panic(name.line, fmt.Sprintf("Multiple values for parameter '%s'.", name.lexeme))
+		}
+		args[idx] = v
+	}
+	// required parameters are the leading ones
+	for k := 0; k < min && k < len(args); k++ {
+		if _, ok := args[k].(missingArgument); ok {
+			Panic(line, fmt.Sprintf("Missing argument for parameter '%s'.", params[k].lexeme))
+		}
+	}
+	return args
+}
+
+func (i *Interpreter) VisitSpawnExpr(expr Expr) any {
+	e, ok := expr.(*Spawn)
+	if !ok {
+		panic("should be spawn type expr")
+	}
+	function, args := i.evaluateCall(e.call)
+	return NewLoxTask(i, function, args)
 }
 
 func (i *Interpreter) VisitLogicalExpr(expr Expr) any {
@@ -164,6 +505,12 @@ func (i *Interpreter) VisitLogicalExpr(expr Expr) any {
 		panic("should be logical type expr")
 	}
 	left := i.evaluate(e.left)
+	if e.operator.typ == QUESTION_QUESTION {
+		if left != nil {
+			return left
+		}
+		return i.evaluate(e.right)
+	}
 	if i.isTruthy(left) {
 		if e.operator.typ == OR {
 			return left
@@ -181,8 +528,18 @@ func (i *Interpreter) VisitGetExpr(expr Expr) any {
 	if !ok {
 		panic("should be get type expr")
 	}
+	if e.name.typ == PRIVATE_IDENTIFIER {
+		instance, owner := i.privateAccess(e.object, e.name)
+		return instance.GetPrivate(owner, e.name)
+	}
 	val := i.evaluate(e.object)
-	if o, ok := val.(*LoxInstance); ok {
+	switch {
+	case val == shortCircuit{}:
+		return val
+	case val == nil && e.optional:
+		return shortCircuit{}
+	}
+	if o, ok := val.(Object); ok {
 		return o.Get(e.name)
 	}
 	Panic(e.name.line, "Only instances have properties")
@@ -194,9 +551,18 @@ func (i *Interpreter) VisitSetExpr(expr Expr) any {
 	if !ok {
 		panic("should be set type expr")
 	}
+	if e.name.typ == PRIVATE_IDENTIFIER {
+		instance, owner := i.privateAccess(e.object, e.name)
+		val := i.evaluate(e.value)
+		instance.SetPrivate(owner, e.name, val)
+		return val
+	}
 	obj := i.evaluate(e.object)
 	if o, ok := obj.(*LoxInstance); ok {
 		val := i.evaluate(e.value)
+		if _, ok := o.Field(e.name.lexeme); !ok {
+			i.allocate(fieldSize)
+		}
 		o.Set(e.name, val)
 		return val
 	}
@@ -204,14 +570,33 @@ func (i *Interpreter) VisitSetExpr(expr Expr) any {
 	return nil
 }
 
+// privateAccess returns the instance a '#name' member is accessed on and the
+// class declaring the running method, only that class' private members are
+// visible.
+func (i *Interpreter) privateAccess(object Expr, name *Token) (*LoxInstance, *LoxClass) {
+	this, ok := object.(*This)
+	if !ok {
+		Panic(name.line, fmt.Sprintf("Private member '%s' can only be accessed through 'this'.", name.lexeme))
+	}
+	local, ok := i.locals[this]
+	if !ok {
+		Panic(name.line, fmt.Sprintf("Private member '%s' can only be accessed inside its class.", name.lexeme))
+	}
+	owner, ok := i.env.GetAt(local.distance, privateOwnerSlot).(*LoxClass)
+	if !ok {
+		Panic(name.line, fmt.Sprintf("Private member '%s' can only be accessed inside its class.", name.lexeme))
+	}
+	return i.env.GetAt(local.distance, 0).(*LoxInstance), owner
+}
+
 func (i *Interpreter) VisitSuperExpr(expr Expr) any {
 	e, ok := expr.(*Super)
 	if !ok {
 		panic("should be super type expr")
 	}
-	distance := i.locals[e]
-	supperclass := i.env.GetAt(distance, "super").(*LoxClass)
-	object := i.env.GetAt(distance-1, "this").(*LoxInstance)
+	distance := i.locals[e].distance
+	supperclass := i.env.GetAt(distance, 0).(*LoxClass)
+	object := i.env.GetAt(distance-1, 0).(*LoxInstance)
 	method := supperclass.FindMethod(e.method)
 	if method == nil {
 		Panic(e.method.line, fmt.Sprintf("Undefined property '%s'.", e.method.lexeme))
@@ -238,16 +623,37 @@ func (i *Interpreter) VisitAssignExpr(expr Expr) any {
 	}
 	// can't assign to undeclared variable
 	val := i.evaluate(e.value)
-	dis, ok := i.locals[expr]
+	local, ok := i.locals[expr]
 	if ok {
-		i.env.AssignAt(dis, e.name, val)
+		i.env.AssignAt(local.distance, local.slot, val)
 	} else {
 		i.globals.Assign(e.name, val)
 	}
 	return val
 }
 
+func (i *Interpreter) VisitMatchExpr(expr Expr) any {
+	e, ok := expr.(*Match)
+	if !ok {
+		panic("should be match type expr")
+	}
+	subject := i.evaluate(e.subject)
+	for _, arm := range e.arms {
+		env, ok := i.matchArm(arm, subject)
+		if !ok {
+			continue
+		}
+		// a return in the body is handed to the statement holding the match
+		if arm.body != nil {
+			return i.executeBlock([]Stmt{arm.body}, env)
+		}
+		return i.evaluateIn(arm.value, env)
+	}
+	return nil
+}
+
 func (i *Interpreter) evaluate(expr Expr) any {
+	i.step()
 	return expr.Accept(i)
 }
 
@@ -267,25 +673,51 @@ func (i *Interpreter) VisitClassStmt(stmt Stmt) any {
 		}
 	}
 
-	i.env.Define(s.name.lexeme, nil)
+	traits := i.evaluateTraits(s.traits)
+
+	slot := i.env.Define(s.name.lexeme, nil)
 
 	if s.superclass != nil {
-		i.env = NewEnvironmentWithAncestor(i.env)
+		i.setEnv(NewEnvironmentWithAncestor(i.env))
 		i.env.Define("super", superclass)
 	}
 
-	methods := make(map[string]Callable)
+	// methods of the class override the ones of its traits, which override
+	// the ones of the superclass. super always refers to the superclass.
+	methods := mergeTraits[*LoxTrait, Callable](s.name, traits, s.methods)
+	loxClass := NewLoxClass(s.name.lexeme, superclass, traits, methods)
 	for _, method := range s.methods {
-		methods[method.name.lexeme] = NewCallable(method, i.env, method.name.lexeme == "init")
+		methods[method.name.lexeme] = NewMethod(method, i.env, method.name.lexeme == "init", loxClass)
 	}
 
-	loxClass := NewLoxClass(s.name.lexeme, superclass, methods)
-
 	if s.superclass != nil {
-		i.env = i.env.enclosing
+		i.setEnv(i.env.enclosing)
+	}
+
+	i.env.Redefine(s.name, slot, loxClass)
+	return nil
+}
+
+func (i *Interpreter) VisitTraitStmt(stmt Stmt) any {
+	s, ok := stmt.(*Trait)
+	if !ok {
+		panic("should be trait type stmt")
 	}
+	traits := i.evaluateTraits(s.traits)
+	methods := mergeTraits[*LoxTrait, Callable](s.name, traits, s.methods)
+	for _, method := range s.methods {
+		methods[method.name.lexeme] = NewCallable(method, i.env, false)
+	}
+	i.env.Define(s.name.lexeme, NewLoxTrait(s.name.lexeme, traits, methods))
+	return nil
+}
 
-	i.env.Assign(s.name, loxClass)
+func (i *Interpreter) VisitEnumStmt(stmt Stmt) any {
+	s, ok := stmt.(*Enum)
+	if !ok {
+		panic("should be enum type stmt")
+	}
+	i.env.Define(s.name.lexeme, NewLoxEnum(s.name.lexeme, s.members))
 	return nil
 }
 
@@ -295,7 +727,7 @@ func (i *Interpreter) VisitPrintStmt(stmt Stmt) any {
 		panic("should be print type stmt")
 	}
 	val := i.evaluate(s.expr)
-	fmt.Println(ToString(val))
+	fmt.Println(i.stringify(val))
 	return nil
 }
 
@@ -312,11 +744,30 @@ func (i *Interpreter) VisitReturnStmt(stmt Stmt) any {
 	if !ok {
 		panic("should be return type stmt")
 	}
+	// the call in 'return f(x);' is left to the caller, which runs it in
+	// place of the returning function
+	if call, ok := s.value.(*Call); ok {
+		function, args := i.evaluateCall(call)
+		return &completion{kind: COMPLETION_TAIL_CALL, callee: function, args: args}
+	}
 	var val any
 	if s.value != nil {
 		val = i.evaluate(s.value)
 	}
-	panic(&ReturnPanic{Value: val})
+	return &completion{kind: COMPLETION_RETURN, value: val}
+}
+
+func (i *Interpreter) VisitYieldStmt(stmt Stmt) any {
+	s, ok := stmt.(*Yield)
+	if !ok {
+		panic("should be yield type stmt")
+	}
+	var val any
+	if s.value != nil {
+		val = i.evaluate(s.value)
+	}
+	i.generator.yield(val)
+	return nil
 }
 
 func (i *Interpreter) VisitVarStmt(stmt Stmt) any {
@@ -328,19 +779,32 @@ func (i *Interpreter) VisitVarStmt(stmt Stmt) any {
 	if s.initializer != nil {
 		val = i.evaluate(s.initializer)
 	}
+	if s.constant {
+		i.env.DefineConst(s.name.lexeme, val)
+		return nil
+	}
 	i.env.Define(s.name.lexeme, val)
 	return nil
 }
 
+func (i *Interpreter) VisitDestructureStmt(stmt Stmt) any {
+	s, ok := stmt.(*Destructure)
+	if !ok {
+		panic("should be destructure type stmt")
+	}
+	destructure(s.pattern, i.evaluate(s.initializer), defineIn(i.env, s.constant))
+	return nil
+}
+
 func (i *Interpreter) VisitIfStmt(stmt Stmt) any {
 	s, ok := stmt.(*If)
 	if !ok {
 		panic("should be if type stmt")
 	}
 	if i.isTruthy(i.evaluate(s.condition)) {
-		i.execute(s.thenBranch)
+		return i.execute(s.thenBranch)
 	} else if s.elseBranch != nil {
-		i.execute(s.elseBranch)
+		return i.execute(s.elseBranch)
 	}
 	return nil
 }
@@ -351,7 +815,11 @@ func (i *Interpreter) VisitWhileStmt(stmt Stmt) any {
 		panic("should be while type stmt")
 	}
 	for i.isTruthy(i.evaluate(s.condition)) {
-		i.execute(s.body)
+		// each iteration counts, whatever its condition and body are
+		i.step()
+		if c, ok := i.execute(s.body).(*completion); ok {
+			return c
+		}
 	}
 	return nil
 }
@@ -361,6 +829,7 @@ func (i *Interpreter) VisitFunctionStmt(stmt Stmt) any {
 	if !ok {
 		panic("should be function type stmt")
 	}
+	i.allocate(closureSize)
 	i.env.Define(s.name.lexeme, NewCallable(s, i.env, false))
 	return s
 }
@@ -370,38 +839,63 @@ func (i *Interpreter) VisitBlockStmt(stmt Stmt) any {
 	if !ok {
 		panic("should be block type stmt")
 	}
-	i.executeBlock(s.statements, NewEnvironmentWithAncestor(i.env))
-	return nil
+	return i.executeBlock(s.statements, NewEnvironmentWithAncestor(i.env))
 }
 
+// execute runs a statement, the result is a *completion when the statement
+// ends the ones enclosing it early.
 func (i *Interpreter) execute(stmt Stmt) any {
+	i.step()
 	return stmt.Accept(i)
 }
 
-func (i *Interpreter) resolve(expr Expr, depth int) {
-	i.locals[expr] = depth
+func (i *Interpreter) resolve(expr Expr, depth, slot int) {
+	i.locals[expr] = localSlot{distance: depth, slot: slot}
 }
 
-func (i *Interpreter) executeBlock(statements []Stmt, env *Environment) {
+// executeBlock runs the statements in env and returns the *completion of the
+// one ending the block early, if any. The environment isn't restored when a
+// runtime error unwinds, Execute resets it.
+func (i *Interpreter) executeBlock(statements []Stmt, env *Environment) any {
 	previous := i.env
-	i.env = env
-	defer func() {
-		i.env = previous
-	}()
+	i.setEnv(env)
 	for _, stmt := range statements {
-		i.execute(stmt)
+		if c, ok := i.execute(stmt).(*completion); ok {
+			i.setEnv(previous)
+			return c
+		}
 	}
+	i.setEnv(previous)
+	return nil
 }
 
+// evaluateIn evaluates expr in env instead of the current environment.
+func (i *Interpreter) evaluateIn(expr Expr, env *Environment) any {
+	previous := i.env
+	i.setEnv(env)
+	val := i.evaluate(expr)
+	i.setEnv(previous)
+	return val
+}
+
+// setEnv makes env the current environment.
+func (i *Interpreter) setEnv(env *Environment) {
+	if i.roots == nil {
+		i.env = env
+		return
+	}
+	i.roots.mu.Lock()
+	i.env = env
+	i.roots.mu.Unlock()
+}
+
+// Execute runs the statements and returns the value of the last one, which
+// is the value of the expression for an expression statement.
 func (i *Interpreter) Execute(stmts []Stmt) any {
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println(err)
-			hadError = true
-		}
-	}()
-	for _, stmt := range stmts {
-		i.execute(stmt)
+	ret, err := i.ExecuteContext(context.Background(), stmts, Options{})
+	if err != nil {
+		printError(err)
+		hadError = true
 	}
-	return nil
+	return ret
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// runBench parses the script once, then runs it b.N times in a fresh
+// interpreter, with the tree walker and with the VM.
+func runBench(b *testing.B, script string) {
+	parser := &Parser{tokens: NewScanner(script).ScanTokens()}
+	stmts := parser.ParseStmts()
+	b.Run("tree", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			b.StopTimer()
+			inter := NewInterpreter()
+			NewResolver(inter).Resolve(stmts)
+			b.StartTimer()
+			inter.Execute(stmts)
+		}
+		if hadError {
+			b.Fatal("script failed")
+		}
+	})
+	b.Run("vm", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			b.StopTimer()
+			inter := NewInterpreter()
+			NewResolver(inter).Resolve(stmts)
+			fn := Compile(stmts, nil, false)
+			b.StartTimer()
+			if _, err := NewVM(inter).InterpretContext(context.Background(), fn, Options{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFib(b *testing.B) {
+	runBench(b, `
+fun fib(n) {
+  if (n < 2) return n;
+  return fib(n - 1) + fib(n - 2);
+}
+fib(20);
+`)
+}
+
+func BenchmarkClosureCalls(b *testing.B) {
+	runBench(b, `
+fun counter() {
+  var count = 0;
+  fun inc(by) {
+    var next = count + by;
+    count = next;
+    return count;
+  }
+  return inc;
+}
+var inc = counter();
+for (var i = 0; i < 20000; i = i + 1) {
+  var a = i;
+  { var b = a; inc(b); }
+}
+`)
+}
+
+func BenchmarkMethodCalls(b *testing.B) {
+	runBench(b, `
+class Point {
+  init(x, y) { this.x = x; this.y = y; }
+  add(other) { return Point(this.x + other.x, this.y + other.y); }
+}
+var p = Point(0, 0);
+var one = Point(1, 1);
+for (var i = 0; i < 10000; i = i + 1) {
+  p = p.add(one);
+}
+`)
+}
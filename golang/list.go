@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// LoxList is a growable sequence of values, created by the List builtin and
+// by rest parameters.
+type LoxList struct {
+	// lists are shared by spawned tasks, so elements access is guarded
+	mu       sync.RWMutex
+	elements []any
+}
+
+func NewLoxList(elements []any) *LoxList {
+	return &LoxList{
+		elements: elements,
+	}
+}
+
+func (l *LoxList) ToString() string {
+	return (&listPrinter{element: Repr}).print(l)
+}
+
+// listPrinter prints lists with element printing their other elements. It
+// tracks the lists being printed, so a list containing itself prints as
+// [...] instead of recursing forever.
+type listPrinter struct {
+	element  func(any) string
+	printing map[*LoxList]bool
+}
+
+func (p *listPrinter) print(l *LoxList) string {
+	if p.printing[l] {
+		return "[...]"
+	}
+	if p.printing == nil {
+		p.printing = make(map[*LoxList]bool)
+	}
+	p.printing[l] = true
+	defer delete(p.printing, l)
+	elements := l.Elements()
+	parts := make([]string, len(elements))
+	for k, el := range elements {
+		if nested, ok := el.(*LoxList); ok {
+			parts[k] = p.print(nested)
+		} else {
+			parts[k] = p.element(el)
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func (l *LoxList) Has(name string) bool {
+	switch name {
+	case "length", "get", "set", "push", "pop":
+		return true
+	}
+	return false
+}
+
+func (l *LoxList) Get(name *Token) any {
+	switch name.lexeme {
+	case "length":
+		return NewPrimitive(0, func(args []any) any {
+			return float64(l.Length())
+		})
+	case "get":
+		return NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+			return l.At(l.index(args[0], i.line))
+		})
+	case "set":
+		return NewInterpreterPrimitive(2, func(i *Interpreter, args []any) any {
+			l.SetAt(l.index(args[0], i.line), args[1])
+			return args[1]
+		})
+	case "push":
+		return NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+			i.allocate(elementSize)
+			l.Push(args[0])
+			return nil
+		})
+	case "pop":
+		return NewPrimitive(0, func(args []any) any {
+			return l.Pop()
+		})
+	}
+	Panic(name.line, fmt.Sprintf("Undefined property '%s'.", name.lexeme))
+	return nil
+}
+
+func (l *LoxList) index(in any, line int) int {
+	idx, ok := in.(float64)
+	if !ok || idx != float64(int(idx)) {
+		Panic(line, fmt.Sprintf("List index must be an integer but got %v", ToString(in)))
+	}
+	n := l.Length()
+	if idx < 0 || int(idx) >= n {
+		Panic(line, fmt.Sprintf("List index %v out of range [0, %v)", idx, n))
+	}
+	return int(idx)
+}
+
+// Elements returns a copy of the elements.
+func (l *LoxList) Elements() []any {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]any{}, l.elements...)
+}
+
+func (l *LoxList) Length() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.elements)
+}
+
+func (l *LoxList) At(idx int) any {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.elements[idx]
+}
+
+func (l *LoxList) SetAt(idx int, value any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.elements[idx] = value
+}
+
+func (l *LoxList) Push(value any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.elements = append(l.elements, value)
+}
+
+// Pop removes the last element and returns it, nil is returned for an empty
+// list.
+func (l *LoxList) Pop() any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.elements) == 0 {
+		return nil
+	}
+	last := l.elements[len(l.elements)-1]
+	l.elements = l.elements[:len(l.elements)-1]
+	return last
+}
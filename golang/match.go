@@ -0,0 +1,300 @@
+package main
+
+import "fmt"
+
+// MatchArm is one 'case patterns => ...' or 'default => ...' of a match. In
+// the statement form body is set, in the expression form value is.
+type MatchArm struct {
+	keyword  *Token
+	patterns []Pattern
+	body     Stmt
+	value    Expr
+}
+
+// matcher tests values against patterns for both backends, which evaluate
+// the expressions of the patterns and compare values their own way.
+type matcher struct {
+	evaluate func(expr Expr) any
+	equal    func(a, b any) bool
+}
+
+// match tests value against the pattern, the values of the names bound by
+// the pattern are stored in bindings.
+func (m *matcher) match(pattern Pattern, value any, bindings map[string]any) bool {
+	switch p := pattern.(type) {
+	case *Value:
+		return m.equal(value, m.evaluate(p.value))
+	case *Bind:
+		if p.name.lexeme != "_" {
+			bindings[p.name.lexeme] = value
+		}
+		return true
+	case *Sequence:
+		list, ok := value.(*LoxList)
+		if !ok {
+			return false
+		}
+		elements := list.Elements()
+		if len(elements) < len(p.elements) || (p.rest == nil && len(elements) != len(p.elements)) {
+			return false
+		}
+		for k, el := range p.elements {
+			if !m.match(el, elements[k], bindings) {
+				return false
+			}
+		}
+		if p.rest != nil && p.rest.lexeme != "_" {
+			bindings[p.rest.lexeme] = NewLoxList(elements[len(p.elements):])
+		}
+		return true
+	case *Fields:
+		for k, name := range p.names {
+			val, ok := fieldOf(value, name)
+			if !ok || !m.match(p.patterns[k], val, bindings) {
+				return false
+			}
+		}
+		return true
+	case *Instance:
+		instance, className, params, ok := instanceOfClass(value, m.evaluate(p.class), p.class.name)
+		if !ok {
+			return false
+		}
+		for k, sub := range p.patterns {
+			field := p.fields[k]
+			if field == nil {
+				// positional patterns match the fields named like the
+				// parameters of init
+				if k >= len(params) {
+					Panic(p.paren.line, fmt.Sprintf("Class %s has no init parameter for positional pattern %v.", className, k+1))
+				}
+				field = params[k]
+			}
+			val, ok := instance.Field(field.lexeme)
+			if !ok || !m.match(sub, val, bindings) {
+				return false
+			}
+		}
+		return true
+	}
+	panic("unknown pattern type")
+}
+
+// instanceOfClass returns value when it's an instance of class or of one of
+// its subclasses, along with the name of class and the parameters of its
+// init.
+func instanceOfClass(value, class any, name *Token) (instance, string, []*Token, bool) {
+	switch c := class.(type) {
+	case *LoxClass:
+		in, ok := value.(*LoxInstance)
+		if !ok || !in.loxClass.IsSubclassOf(c) {
+			return nil, "", nil, false
+		}
+		return in, c.name, c.Params(), true
+	case *ObjClass:
+		in, ok := value.(*ObjInstance)
+		if !ok || !in.class.IsSubclassOf(c) {
+			return nil, "", nil, false
+		}
+		var params []*Token
+		if init, ok := c.methods["init"]; ok {
+			params = init.function.params
+		}
+		return in, c.name, params, true
+	}
+	Panic(name.line, fmt.Sprintf("'%s' is not a class.", name.lexeme))
+	return nil, "", nil, false
+}
+
+// patternExprs returns the expressions of a pattern the VM evaluates before
+// matching, literals are read from the pattern.
+func patternExprs(pattern Pattern) []Expr {
+	var exprs []Expr
+	var walk func(pattern Pattern)
+	walk = func(pattern Pattern) {
+		switch p := pattern.(type) {
+		case *Value:
+			if _, ok := p.value.(*Literal); !ok {
+				exprs = append(exprs, p.value)
+			}
+		case *Sequence:
+			for _, el := range p.elements {
+				walk(el)
+			}
+		case *Fields:
+			for _, sub := range p.patterns {
+				walk(sub)
+			}
+		case *Instance:
+			exprs = append(exprs, p.class)
+			for _, sub := range p.patterns {
+				walk(sub)
+			}
+		}
+	}
+	walk(pattern)
+	return exprs
+}
+
+// matchArm returns the environment the arm runs in when one of its patterns
+// matches the subject.
+func (i *Interpreter) matchArm(arm *MatchArm, subject any) (*Environment, bool) {
+	env := NewEnvironmentWithAncestor(i.env)
+	if arm.patterns == nil {
+		return env, true
+	}
+	previous := i.env
+	i.setEnv(env)
+	defer func() {
+		i.setEnv(previous)
+	}()
+	m := &matcher{evaluate: i.evaluate, equal: i.isEqual}
+	for _, pattern := range arm.patterns {
+		bindings := make(map[string]any)
+		if m.match(pattern, subject, bindings) {
+			// the names an alternative doesn't bind are left nil
+			for _, name := range armNames(arm) {
+				env.Define(name, bindings[name])
+			}
+			return env, true
+		}
+	}
+	return nil, false
+}
+
+// armNames returns the names bound by the patterns of an arm in the order
+// the Resolver gives them slots.
+func armNames(arm *MatchArm) []string {
+	var names []string
+	seen := make(map[string]bool)
+	var walk func(pattern Pattern)
+	bind := func(name *Token) {
+		if name.lexeme != "_" && !seen[name.lexeme] {
+			seen[name.lexeme] = true
+			names = append(names, name.lexeme)
+		}
+	}
+	walk = func(pattern Pattern) {
+		switch p := pattern.(type) {
+		case *Bind:
+			bind(p.name)
+		case *Sequence:
+			for _, el := range p.elements {
+				walk(el)
+			}
+			if p.rest != nil {
+				bind(p.rest)
+			}
+		case *Fields:
+			for _, sub := range p.patterns {
+				walk(sub)
+			}
+		case *Instance:
+			for _, sub := range p.patterns {
+				walk(sub)
+			}
+		}
+	}
+	for _, pattern := range arm.patterns {
+		walk(pattern)
+	}
+	return names
+}
+
+// fieldOf returns the field of an instance, or the property of another
+// object, used by the '{...}' patterns.
+func fieldOf(value any, name *Token) (any, bool) {
+	switch v := value.(type) {
+	case instance:
+		return v.Field(name.lexeme)
+	case Object:
+		if !v.Has(name.lexeme) {
+			return nil, false
+		}
+		return v.Get(name), true
+	}
+	return nil, false
+}
+
+// destructure hands each name of a destructuring pattern and the value it
+// takes to bind, in the order of boundNames. A runtime error names the index
+// or field value is missing.
+func destructure(pattern Pattern, value any, bind func(name *Token, value any)) {
+	switch p := pattern.(type) {
+	case *Bind:
+		if p.name.lexeme != "_" {
+			bind(p.name, value)
+		}
+	case *Sequence:
+		list, ok := value.(*LoxList)
+		if !ok {
+			Panic(p.bracket.line, fmt.Sprintf("Can't destructure %v as a list.", Repr(value)))
+		}
+		elements := list.Elements()
+		for k, el := range p.elements {
+			if k >= len(elements) {
+				Panic(p.bracket.line, fmt.Sprintf("Missing index %v in list of length %v.", k, len(elements)))
+			}
+			destructure(el, elements[k], bind)
+		}
+		if p.rest != nil {
+			rest := []any{}
+			if len(elements) > len(p.elements) {
+				rest = append(rest, elements[len(p.elements):]...)
+			}
+			destructure(&Bind{p.rest}, NewLoxList(rest), bind)
+		}
+	case *Fields:
+		if _, ok := value.(Object); !ok {
+			Panic(p.brace.line, fmt.Sprintf("Can't destructure fields of %v.", Repr(value)))
+		}
+		for k, name := range p.names {
+			val, ok := fieldOf(value, name)
+			if !ok {
+				Panic(name.line, fmt.Sprintf("Missing field '%s' in %v.", name.lexeme, Repr(value)))
+			}
+			destructure(p.patterns[k], val, bind)
+		}
+	default:
+		panic("unknown destructuring pattern type")
+	}
+}
+
+// boundNames returns the names a destructuring pattern binds.
+func boundNames(pattern Pattern) []*Token {
+	var names []*Token
+	var walk func(pattern Pattern)
+	walk = func(pattern Pattern) {
+		switch p := pattern.(type) {
+		case *Bind:
+			if p.name.lexeme != "_" {
+				names = append(names, p.name)
+			}
+		case *Sequence:
+			for _, el := range p.elements {
+				walk(el)
+			}
+			if p.rest != nil {
+				walk(&Bind{p.rest})
+			}
+		case *Fields:
+			for _, sub := range p.patterns {
+				walk(sub)
+			}
+		}
+	}
+	walk(pattern)
+	return names
+}
+
+// defineIn returns the bind function of destructure defining the names in
+// env.
+func defineIn(env *Environment, constant bool) func(name *Token, value any) {
+	return func(name *Token, value any) {
+		if constant {
+			env.DefineConst(name.lexeme, value)
+		} else {
+			env.Define(name.lexeme, value)
+		}
+	}
+}
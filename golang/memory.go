@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// the approximate sizes in bytes of what scripts allocate, they include the
+// Go headers the values come with.
+const (
+	stringSize   = 16
+	instanceSize = 64
+	fieldSize    = 32
+	closureSize  = 96
+	listSize     = 40
+	elementSize  = 16
+	bigIntSize   = 32
+	decimalSize  = bigIntSize + 16
+	wordSize     = bits.UintSize / 8
+)
+
+// MemoryStats is what the scripts of a run allocated.
+type MemoryStats struct {
+	// Allocations and Bytes count every allocation, freed memory isn't
+	// subtracted
+	Allocations int64
+	Bytes       int64
+	// Live is the memory the values still reachable held when it was last
+	// measured, which only happens under Options.MaxMemory
+	Live int64
+}
+
+// MemoryStats returns what the last run of ExecuteContext allocated.
+func (i *Interpreter) MemoryStats() MemoryStats {
+	return i.memoryStats
+}
+
+func bigSize(n *big.Int) int {
+	return bigIntSize + len(n.Bits())*wordSize
+}
+
+// allocate accounts the size in bytes of a string, an instance, a function,
+// a list or a big number the script creates, it does nothing outside of a
+// run.
+func (i *Interpreter) allocate(size int) {
+	if i.budget != nil {
+		i.budget.allocate(i, size)
+	}
+}
+
+// allocateNumber accounts the result of an arithmetic operation when it's a
+// big integer or a decimal, and returns it.
+func (i *Interpreter) allocateNumber(v any) any {
+	switch n := v.(type) {
+	case *big.Int:
+		i.allocate(bigSize(n))
+	case *Decimal:
+		i.allocate(decimalSize + len(n.unscaled.Bits())*wordSize)
+	}
+	return v
+}
+
+// remeasureShare is the share of Options.MaxMemory allocated between two
+// measures at least, so a script running close to its quota doesn't walk its
+// values at every allocation.
+const remeasureShare = 8
+
+// allocate counts an allocation of i. Once what was allocated since the
+// memory was measured last goes over the room left under Options.MaxMemory,
+// or over a share of it when less is left, the values reachable from i are
+// measured again, so the temporaries dropped since don't count.
+func (b *budget) allocate(i *Interpreter, size int) {
+	allocs := atomic.AddInt64(&b.allocs, 1)
+	atomic.AddInt64(&b.bytes, int64(size))
+	if b.opts.MaxAllocations > 0 && allocs > b.opts.MaxAllocations {
+		panic(fmt.Errorf("%w: more than %d allocations", ErrBudgetExceeded, b.opts.MaxAllocations))
+	}
+	if b.opts.MaxMemory == 0 {
+		return
+	}
+	pending := atomic.AddInt64(&b.pending, int64(size))
+	if pending <= b.slack() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// another task may have measured in the meantime
+	if atomic.LoadInt64(&b.pending) <= b.slack() {
+		return
+	}
+	atomic.StoreInt64(&b.pending, 0)
+	// the value being allocated isn't reachable yet
+	live := i.measure() + int64(size)
+	atomic.StoreInt64(&b.live, live)
+	if live > b.opts.MaxMemory {
+		panic(fmt.Errorf("%w: more than %d bytes in use", ErrOutOfMemory, b.opts.MaxMemory))
+	}
+}
+
+// slack returns how many bytes may be allocated before the memory is
+// measured again.
+func (b *budget) slack() int64 {
+	left := b.opts.MaxMemory - atomic.LoadInt64(&b.live)
+	if share := b.opts.MaxMemory / remeasureShare; left < share {
+		return share
+	}
+	return left
+}
+
+func (b *budget) memoryStats() MemoryStats {
+	return MemoryStats{
+		Allocations: atomic.LoadInt64(&b.allocs),
+		Bytes:       atomic.LoadInt64(&b.bytes),
+		Live:        atomic.LoadInt64(&b.live),
+	}
+}
+
+// roots guards the environment and the calls of an interpreter running
+// alongside other tasks, so they can be measured from another goroutine.
+type roots struct {
+	mu    sync.Mutex
+	inter *Interpreter
+}
+
+// track makes the memory of the run count the locals of i, which runs
+// alongside other tasks. It returns the function to call once i is done.
+func (b *budget) track(i *Interpreter) func() {
+	if b.opts.MaxMemory == 0 || i.roots != nil {
+		return func() {}
+	}
+	r := &roots{inter: i}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tasks == nil {
+		b.tasks = make(map[*roots]bool)
+	}
+	b.tasks[r] = true
+	i.roots = r
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.tasks, r)
+	}
+}
+
+// measure returns the memory held by the values reachable from the globals,
+// the locals of the calls in progress of i, of the interpreters resuming it
+// and of the tasks running, and the VM.
+func (i *Interpreter) measure() int64 {
+	m := &meter{seen: make(map[any]bool)}
+	m.push(i.globals)
+	for x := i; x != nil; x = x.caller {
+		m.push(x.env)
+		for _, frame := range x.frames {
+			m.push(frame.env)
+		}
+		if x.vm != nil {
+			x.vm.roots(m)
+		}
+	}
+	if i.budget != nil {
+		for r := range i.budget.tasks {
+			r.mu.Lock()
+			m.push(r.inter.env)
+			for _, frame := range r.inter.frames {
+				m.push(frame.env)
+			}
+			// the measure holds the lock of the VM, its tasks are paused
+			if r.inter.vm != nil {
+				r.inter.vm.roots(m)
+			}
+			r.mu.Unlock()
+		}
+	}
+	return m.run()
+}
+
+// meter walks values and adds up their sizes, counting each of them once.
+type meter struct {
+	seen  map[any]bool
+	queue []any
+	bytes int64
+}
+
+func (m *meter) push(v any) {
+	m.queue = append(m.queue, v)
+}
+
+// first reports whether the value identified by key is met for the first
+// time.
+func (m *meter) first(key any) bool {
+	if m.seen[key] {
+		return false
+	}
+	m.seen[key] = true
+	return true
+}
+
+func (m *meter) run() int64 {
+	for len(m.queue) > 0 {
+		v := m.queue[len(m.queue)-1]
+		m.queue = m.queue[:len(m.queue)-1]
+		m.visit(v)
+	}
+	return m.bytes
+}
+
+// stringData identifies the bytes of a string, strings sharing them are
+// counted once.
+func stringData(s string) *byte {
+	return unsafe.StringData(s)
+}
+
+func (m *meter) visit(v any) {
+	switch v := v.(type) {
+	case string:
+		if len(v) != 0 && m.first(stringData(v)) {
+			m.bytes += int64(stringSize + len(v))
+		}
+	case *big.Int:
+		if m.first(v) {
+			m.bytes += int64(bigSize(v))
+		}
+	case *Decimal:
+		if m.first(v) {
+			m.bytes += int64(decimalSize + len(v.unscaled.Bits())*wordSize)
+		}
+	case *Environment:
+		if v == nil || !m.first(v) {
+			return
+		}
+		v.mu.RLock()
+		m.bytes += int64(elementSize * (len(v.values) + len(v.envs)))
+		for _, value := range v.values {
+			m.push(value)
+		}
+		for _, value := range v.envs {
+			m.push(value)
+		}
+		v.mu.RUnlock()
+		m.push(v.enclosing)
+	case *LoxList:
+		if !m.first(v) {
+			return
+		}
+		elements := v.Elements()
+		m.bytes += int64(listSize + elementSize*len(elements))
+		for _, el := range elements {
+			m.push(el)
+		}
+	case *LoxInstance:
+		if !m.first(v) {
+			return
+		}
+		v.mu.RLock()
+		m.bytes += int64(instanceSize + fieldSize*len(v.fileds))
+		for _, value := range v.fileds {
+			m.push(value)
+		}
+		for _, fields := range v.private {
+			m.bytes += int64(fieldSize * len(fields))
+			for _, value := range fields {
+				m.push(value)
+			}
+		}
+		v.mu.RUnlock()
+		m.push(v.loxClass)
+	case *LoxClass:
+		if v == nil || !m.first(v) {
+			return
+		}
+		for _, method := range v.methods {
+			m.push(method)
+		}
+		m.push(v.superclass)
+	case *callableImpl:
+		if v.closure == nil || !m.first(v) {
+			return
+		}
+		m.bytes += closureSize
+		m.push(v.closure)
+	case *LoxGenerator:
+		if !m.first(v) {
+			return
+		}
+		switch body := v.body.(type) {
+		case *generatorState:
+			m.push(body.env)
+		case *vmGenerator:
+			body.vm.roots(m)
+		}
+	case *LoxChannel:
+		if !m.first(v) {
+			return
+		}
+		v.mu.Lock()
+		m.bytes += int64(elementSize * len(v.buffer))
+		for _, value := range v.buffer {
+			m.push(value)
+		}
+		v.mu.Unlock()
+	case *LoxTask:
+		if !m.first(v) {
+			return
+		}
+		select {
+		case <-v.done:
+			m.push(v.result)
+		default:
+		}
+	case *ObjClosure:
+		if !m.first(v) {
+			return
+		}
+		m.bytes += closureSize
+		for _, up := range v.upvalues {
+			// open upvalues point into the stack, which is walked
+			if up.isClosed {
+				m.push(up.closed)
+			}
+		}
+	case *ObjClass:
+		if v == nil || !m.first(v) {
+			return
+		}
+		for _, method := range v.methods {
+			m.push(method)
+		}
+		m.push(v.superclass)
+	case *ObjTrait:
+		if !m.first(v) {
+			return
+		}
+		for _, method := range v.methods {
+			m.push(method)
+		}
+	case *ObjInstance:
+		if !m.first(v) {
+			return
+		}
+		m.bytes += int64(instanceSize + fieldSize*len(v.fields))
+		for _, value := range v.fields {
+			m.push(value)
+		}
+		for _, fields := range v.private {
+			m.bytes += int64(fieldSize * len(fields))
+			for _, value := range fields {
+				m.push(value)
+			}
+		}
+		m.push(v.class)
+	case *ObjBoundMethod:
+		m.push(v.receiver)
+		m.push(v.method)
+	}
+}
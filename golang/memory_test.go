@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemporariesAreFreed(t *testing.T) {
+	source := `for (var k = 0; k < 100000; k = k + 1) { var t = "abc" + str(k); }`
+	for _, useVM := range []bool{false, true} {
+		_, err := execute(t, source, useVM, Options{MaxMemory: 1 << 20})
+		assert.NoError(t, err)
+	}
+}
+
+func TestMemoryQuota(t *testing.T) {
+	for _, source := range []string{
+		// a list held by the global
+		`var l = List(); for (var k = 0; k < 100000; k = k + 1) l.push(k);`,
+		// held by a local of the caller
+		`fun fill(l) { for (var k = 0; k < 100000; k = k + 1) l.push(k); } fun f() { var l = List(); fill(l); } f();`,
+		// fields
+		`class A {} var a; for (var k = 0; k < 100000; k = k + 1) { var b = A(); b.next = a; a = b; }`,
+		// a big integer
+		`var n = 3; for (var k = 0; k < 30; k = k + 1) n = n * n;`,
+	} {
+		_, err := execute(t, source, false, Options{MaxMemory: 1 << 20})
+		assert.ErrorIs(t, err, ErrOutOfMemory, source)
+	}
+	for _, source := range []string{
+		`class A {} var a; for (var k = 0; k < 100000; k = k + 1) { var b = A(); b.next = a; a = b; }`,
+		`var s = "x"; for (var k = 0; k < 30; k = k + 1) s = s + s;`,
+		`var n = 3; for (var k = 0; k < 30; k = k + 1) n = n * n;`,
+	} {
+		_, err := execute(t, source, true, Options{MaxMemory: 1 << 20})
+		assert.ErrorIs(t, err, ErrOutOfMemory, source)
+	}
+}
+
+// TestGeneratorMemoryCounts checks the locals of a generator count, while it
+// runs and while it's paused.
+func TestGeneratorMemoryCounts(t *testing.T) {
+	source := `fun g() {
+  var l = List();
+  while (true) {
+    for (var k = 0; k < 1000; k = k + 1) l.push(k);
+    yield l.length();
+  }
+}
+var it = g();
+for (var k = 0; k < 200; k = k + 1) it.next();`
+	for _, useVM := range []bool{false, true} {
+		_, err := execute(t, source, useVM, Options{MaxMemory: 1 << 20})
+		assert.ErrorIs(t, err, ErrOutOfMemory, "vm: %v", useVM)
+	}
+}
+
+// TestChannelMemoryCounts checks the values buffered in a channel count.
+func TestChannelMemoryCounts(t *testing.T) {
+	source := `var s = "x";
+for (var k = 0; k < 10; k = k + 1) s = s + s;
+var c = Channel(1000);
+for (var k = 0; k < 1000; k = k + 1) c.send(s + str(k));`
+	_, err := execute(t, source, false, Options{MaxMemory: 200000})
+	assert.ErrorIs(t, err, ErrOutOfMemory)
+}
+
+// TestTaskMemoryCounts checks the locals of a task count while it waits,
+// when another task measures.
+func TestTaskMemoryCounts(t *testing.T) {
+	source := `var ch = Channel(0);
+fun work() {
+  var l = List();
+  for (var k = 0; k < 40000; k = k + 1) l.push(k);
+  ch.send(1);
+  ch.recv();
+}
+spawn work();
+ch.recv();
+var m = List();
+for (var k = 0; k < 40000; k = k + 1) m.push(k);`
+	for _, useVM := range []bool{false, true} {
+		_, err := execute(t, source, useVM, Options{MaxMemory: 1 << 20})
+		assert.ErrorIs(t, err, ErrOutOfMemory)
+	}
+}
+
+// TestCloseToMemoryQuota checks a script allocating temporaries just under
+// its quota doesn't measure its memory at every allocation.
+func TestCloseToMemoryQuota(t *testing.T) {
+	setup := `var l = List(); for (var k = 0; k < 5000; k = k + 1) l.push("s" + str(k));`
+	source := setup + `for (var k = 0; k < 20000; k = k + 1) { var t = "abc" + str(k); }`
+	inter := NewInterpreter()
+	defer inter.Close()
+	_, err := executeIn(t, context.Background(), inter, setup, false, Options{})
+	assert.NoError(t, err)
+	quota := inter.measure() + 200
+	for _, useVM := range []bool{false, true} {
+		start := time.Now()
+		_, err := execute(t, source, useVM, Options{})
+		assert.NoError(t, err)
+		unlimited := time.Since(start)
+		start = time.Now()
+		_, err = execute(t, source, useVM, Options{MaxMemory: quota})
+		assert.NoError(t, err, "vm: %v", useVM)
+		assert.Less(t, time.Since(start), 5*unlimited+100*time.Millisecond, "vm: %v", useVM)
+	}
+}
+
+func TestMemoryStatsPerRun(t *testing.T) {
+	inter := NewInterpreter()
+	defer inter.Close()
+	stmts := parse(t, inter, `var l = List(1, 2, 3);`)
+	captureOutput(t, func() {
+		for k := 0; k < 3; k++ {
+			_, err := inter.ExecuteContext(context.Background(), stmts, Options{})
+			assert.NoError(t, err)
+			assert.Equal(t, MemoryStats{Allocations: 1, Bytes: listSize + 3*elementSize}, inter.MemoryStats())
+		}
+	})
+}
+
+func TestMaxAllocations(t *testing.T) {
+	_, err := execute(t, `for (var k = 0; k < 100; k = k + 1) List();`, false, Options{MaxAllocations: 10})
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxSafeInteger is the first integer whose successor a float64 can't hold,
+// integer results reaching it are promoted to big integers.
+const maxSafeInteger = 1 << 53
+
+// divisionScale is the number of fractional digits kept by a decimal
+// division which doesn't terminate.
+const divisionScale = 16
+
+// Decimal is an exact base-10 number, the value is unscaled / 10^scale.
+type Decimal struct {
+	unscaled *big.Int
+	scale    int
+}
+
+var bigTen = big.NewInt(10)
+
+// ParseDecimal parses a decimal literal such as "-12.50".
+func ParseDecimal(s string) (*Decimal, error) {
+	digits := strings.TrimSpace(s)
+	scale := 0
+	if idx := strings.IndexByte(digits, '.'); idx >= 0 {
+		scale = len(digits) - idx - 1
+		digits = digits[:idx] + digits[idx+1:]
+	}
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+	if !ok || scale < 0 {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	return &Decimal{unscaled: unscaled, scale: scale}, nil
+}
+
+func (d *Decimal) String() string {
+	digits := new(big.Int).Abs(d.unscaled).String()
+	if d.scale > 0 {
+		if len(digits) <= d.scale {
+			digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+	}
+	if d.unscaled.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// rescale returns the unscaled value of d at a larger scale.
+func (d *Decimal) rescale(scale int) *big.Int {
+	factor := new(big.Int).Exp(bigTen, big.NewInt(int64(scale-d.scale)), nil)
+	return factor.Mul(factor, d.unscaled)
+}
+
+func (d *Decimal) Cmp(o *Decimal) int {
+	scale := d.scale
+	if o.scale > scale {
+		scale = o.scale
+	}
+	return d.rescale(scale).Cmp(o.rescale(scale))
+}
+
+func (d *Decimal) Add(o *Decimal) *Decimal {
+	scale := d.scale
+	if o.scale > scale {
+		scale = o.scale
+	}
+	return &Decimal{unscaled: new(big.Int).Add(d.rescale(scale), o.rescale(scale)), scale: scale}
+}
+
+func (d *Decimal) Neg() *Decimal {
+	return &Decimal{unscaled: new(big.Int).Neg(d.unscaled), scale: d.scale}
+}
+
+func (d *Decimal) Mul(o *Decimal) *Decimal {
+	return &Decimal{unscaled: new(big.Int).Mul(d.unscaled, o.unscaled), scale: d.scale + o.scale}
+}
+
+// Quo divides exactly when the quotient terminates within divisionScale
+// digits, it is rounded half to even otherwise. Trailing zeros past the
+// scale of the operands are dropped.
+func (d *Decimal) Quo(o *Decimal) *Decimal {
+	keep := d.scale
+	if o.scale > keep {
+		keep = o.scale
+	}
+	scale := keep + divisionScale
+	// unscaled = d * 10^(scale + o.scale - d.scale) / o.unscaled
+	num := new(big.Int).Mul(d.unscaled, new(big.Int).Exp(bigTen, big.NewInt(int64(scale+o.scale-d.scale)), nil))
+	quo, rem := new(big.Int).QuoRem(num, o.unscaled, new(big.Int))
+	// round half to even
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+	if c := twice.Cmp(new(big.Int).Abs(o.unscaled)); c > 0 || (c == 0 && quo.Bit(0) == 1) {
+		if num.Sign()*o.unscaled.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	m := new(big.Int)
+	for scale > keep {
+		q, r := new(big.Int).QuoRem(quo, bigTen, m)
+		if r.Sign() != 0 {
+			break
+		}
+		quo = q
+		scale--
+	}
+	return &Decimal{unscaled: quo, scale: scale}
+}
+
+// toDecimal converts a number of any kind, floats are converted through
+// their shortest decimal form.
+func toDecimal(v any) *Decimal {
+	switch n := v.(type) {
+	case *Decimal:
+		return n
+	case *big.Int:
+		return &Decimal{unscaled: n, scale: 0}
+	case float64:
+		d, err := ParseDecimal(strconv.FormatFloat(n, 'f', -1, 64))
+		if err != nil {
+			panic(fmt.Sprintf("Can't convert %v to a decimal.", ToString(v)))
+		}
+		return d
+	}
+	return nil
+}
+
+func isInteger(f float64) bool {
+	return !math.IsInf(f, 0) && f == math.Trunc(f)
+}
+
+// toBigInt converts an integral number, ok is false for anything else.
+func toBigInt(v any) (*big.Int, bool) {
+	switch n := v.(type) {
+	case *big.Int:
+		return n, true
+	case float64:
+		if !isInteger(n) {
+			return nil, false
+		}
+		b, _ := new(big.Float).SetFloat64(n).Int(nil)
+		return b, true
+	}
+	return nil, false
+}
+
+func isBigNumber(v any) bool {
+	switch v.(type) {
+	case *big.Int, *Decimal:
+		return true
+	}
+	return false
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case float64, *big.Int, *Decimal:
+		return true
+	}
+	return false
+}
+
+// bigArithmetic applies an operator to two numbers when either of them is a
+// big integer or a decimal. Mixed operands are converted to the wider kind:
+// an integral float to a big integer, anything else to a decimal.
+func bigArithmetic(operator *Token, left, right any) any {
+	if !isNumber(left) || !isNumber(right) {
+		Panic(operator.line, fmt.Sprintf("Operands of '%s' must be numbers but got %v and %v", operator.lexeme, ToString(left), ToString(right)))
+	}
+	l, lok := toBigInt(left)
+	r, rok := toBigInt(right)
+	if lok && rok {
+		switch operator.typ {
+		case PLUS:
+			return new(big.Int).Add(l, r)
+		case MINUS:
+			return new(big.Int).Sub(l, r)
+		case STAR:
+			return new(big.Int).Mul(l, r)
+		case SLASH:
+			if r.Sign() == 0 {
+				Panic(operator.line, "Division by zero.")
+			}
+			// integers divide truncating toward zero
+			return new(big.Int).Quo(l, r)
+		}
+		return compareResult(operator, l.Cmp(r))
+	}
+	ld, rd := toDecimal(left), toDecimal(right)
+	switch operator.typ {
+	case PLUS:
+		return ld.Add(rd)
+	case MINUS:
+		return ld.Add(rd.Neg())
+	case STAR:
+		return ld.Mul(rd)
+	case SLASH:
+		if rd.unscaled.Sign() == 0 {
+			Panic(operator.line, "Division by zero.")
+		}
+		return ld.Quo(rd)
+	}
+	return compareResult(operator, ld.Cmp(rd))
+}
+
+func compareResult(operator *Token, cmp int) any {
+	switch operator.typ {
+	case GREATER:
+		return cmp > 0
+	case GREATER_EQUAL:
+		return cmp >= 0
+	case LESS:
+		return cmp < 0
+	case LESS_EQUAL:
+		return cmp <= 0
+	}
+	Panic(operator.line, fmt.Sprintf("Unsupported operator '%s' for numbers.", operator.lexeme))
+	return nil
+}
+
+// promoteOverflow redoes an integer operation on big integers when the
+// float64 result isn't exact anymore.
+func promoteOverflow(operator *Token, left, right, result float64) any {
+	if math.Abs(result) < maxSafeInteger || !isInteger(left) || !isInteger(right) {
+		return result
+	}
+	if math.Abs(left) > maxSafeInteger || math.Abs(right) > maxSafeInteger {
+		// the operands already lost precision
+		return result
+	}
+	return bigArithmetic(operator, left, right)
+}
+
+// floats returns a and b when both are plain numbers, which compare without
+// being promoted.
+func floats(a, b any) (float64, float64, bool) {
+	l, ok := a.(float64)
+	if !ok {
+		return 0, 0, false
+	}
+	r, ok := b.(float64)
+	return l, r, ok
+}
+
+// numbersEqual compares numbers of any kind by value.
+func numbersEqual(a, b any) bool {
+	if l, r, ok := floats(a, b); ok {
+		return l == r
+	}
+	l, lok := toBigInt(a)
+	r, rok := toBigInt(b)
+	if lok && rok {
+		return l.Cmp(r) == 0
+	}
+	for _, v := range []any{a, b} {
+		// NaN and infinities have no exact form
+		if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return a == b
+		}
+	}
+	return toDecimal(a).Cmp(toDecimal(b)) == 0
+}
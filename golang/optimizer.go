@@ -0,0 +1,438 @@
+package main
+
+// Optimizer rewrites the resolved statements before they run: expressions
+// on literals are folded into literals, branches on constant conditions are
+// dropped and so is the code following a return. Variables keep their nodes
+// so the locals the Resolver recorded still apply.
+type Optimizer struct {
+	inter *Interpreter
+}
+
+func NewOptimizer(inter *Interpreter) *Optimizer {
+	return &Optimizer{inter: inter}
+}
+
+func (o *Optimizer) Optimize(stmts []Stmt) []Stmt {
+	return o.optimizeStmts(stmts)
+}
+
+func (o *Optimizer) optimizeExpr(expr Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+	return expr.Accept(o).(Expr)
+}
+
+func (o *Optimizer) optimizeExprs(exprs []Expr) {
+	for k, expr := range exprs {
+		exprs[k] = o.optimizeExpr(expr)
+	}
+}
+
+// optimizeStmt returns nil when the statement can be dropped.
+func (o *Optimizer) optimizeStmt(stmt Stmt) Stmt {
+	if stmt == nil {
+		return nil
+	}
+	ret, _ := stmt.Accept(o).(Stmt)
+	return ret
+}
+
+// optimizeBody is optimizeStmt for the statements which can't be dropped,
+// like the body of a loop.
+func (o *Optimizer) optimizeBody(stmt Stmt) Stmt {
+	if ret := o.optimizeStmt(stmt); ret != nil {
+		return ret
+	}
+	return &Block{}
+}
+
+// optimizeStmts drops the statements which do nothing and the ones after a
+// return, which can't run.
+func (o *Optimizer) optimizeStmts(stmts []Stmt) []Stmt {
+	ret := make([]Stmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		stmt = o.optimizeStmt(stmt)
+		if stmt == nil {
+			continue
+		}
+		ret = append(ret, stmt)
+		if _, ok := stmt.(*Return); ok {
+			break
+		}
+	}
+	return ret
+}
+
+func (o *Optimizer) optimizeFunction(fn *Function) {
+	o.optimizeExprs(fn.defaults)
+	for _, pattern := range fn.patterns {
+		o.optimizePattern(pattern)
+	}
+	fn.body = o.optimizeStmts(fn.body)
+}
+
+func (o *Optimizer) optimizePattern(pattern Pattern) {
+	if pattern != nil {
+		pattern.Accept(o)
+	}
+}
+
+// fold evaluates an expression whose operands are literals, it's left alone
+// when evaluating it is a runtime error so the error is raised when it runs.
+// The literal takes the line of the operator.
+func (o *Optimizer) fold(expr Expr, line int) (ret Expr) {
+	// Panic flags the error, which isn't one until the expression runs
+	failed := hadError
+	defer func() {
+		if recover() != nil {
+			hadError = failed
+			ret = expr
+		}
+	}()
+	return &Literal{value: o.inter.evaluate(expr), line: line}
+}
+
+func isLiteral(exprs ...Expr) bool {
+	for _, expr := range exprs {
+		if _, ok := expr.(*Literal); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *Optimizer) VisitBinaryExpr(expr Expr) any {
+	e, ok := expr.(*Binary)
+	if !ok {
+		panic("should be binary type expr")
+	}
+	e.left = o.optimizeExpr(e.left)
+	e.right = o.optimizeExpr(e.right)
+	if isLiteral(e.left, e.right) {
+		return o.fold(e, e.operator.line)
+	}
+	return e
+}
+
+func (o *Optimizer) VisitGroupingExpr(expr Expr) any {
+	e, ok := expr.(*Grouping)
+	if !ok {
+		panic("should be grouping type expr")
+	}
+	e.expression = o.optimizeExpr(e.expression)
+	if isLiteral(e.expression) {
+		return e.expression
+	}
+	return e
+}
+
+func (o *Optimizer) VisitLiteralExpr(expr Expr) any {
+	return expr
+}
+
+func (o *Optimizer) VisitUnaryExpr(expr Expr) any {
+	e, ok := expr.(*Unary)
+	if !ok {
+		panic("should be unary type expr")
+	}
+	e.right = o.optimizeExpr(e.right)
+	if isLiteral(e.right) {
+		return o.fold(e, e.operator.line)
+	}
+	return e
+}
+
+func (o *Optimizer) VisitVariableExpr(expr Expr) any {
+	return expr
+}
+
+func (o *Optimizer) VisitAssignExpr(expr Expr) any {
+	e, ok := expr.(*Assign)
+	if !ok {
+		panic("should be assign type expr")
+	}
+	e.value = o.optimizeExpr(e.value)
+	return e
+}
+
+// VisitLogicalExpr picks the operand a logical expression results in when
+// the left one is a literal.
+func (o *Optimizer) VisitLogicalExpr(expr Expr) any {
+	e, ok := expr.(*Logical)
+	if !ok {
+		panic("should be logical type expr")
+	}
+	e.left = o.optimizeExpr(e.left)
+	e.right = o.optimizeExpr(e.right)
+	left, ok := e.left.(*Literal)
+	if !ok {
+		return e
+	}
+	switch e.operator.typ {
+	case QUESTION_QUESTION:
+		if left.value != nil {
+			return left
+		}
+	case OR:
+		if o.inter.isTruthy(left.value) {
+			return left
+		}
+	case AND:
+		if !o.inter.isTruthy(left.value) {
+			return left
+		}
+	}
+	return e.right
+}
+
+func (o *Optimizer) VisitCallExpr(expr Expr) any {
+	e, ok := expr.(*Call)
+	if !ok {
+		panic("should be call type expr")
+	}
+	e.callee = o.optimizeExpr(e.callee)
+	o.optimizeExprs(e.arguments)
+	return e
+}
+
+func (o *Optimizer) VisitGetExpr(expr Expr) any {
+	e, ok := expr.(*Get)
+	if !ok {
+		panic("should be get type expr")
+	}
+	e.object = o.optimizeExpr(e.object)
+	return e
+}
+
+func (o *Optimizer) VisitSetExpr(expr Expr) any {
+	e, ok := expr.(*Set)
+	if !ok {
+		panic("should be set type expr")
+	}
+	e.object = o.optimizeExpr(e.object)
+	e.value = o.optimizeExpr(e.value)
+	return e
+}
+
+func (o *Optimizer) VisitSuperExpr(expr Expr) any {
+	return expr
+}
+
+func (o *Optimizer) VisitThisExpr(expr Expr) any {
+	return expr
+}
+
+func (o *Optimizer) VisitSpawnExpr(expr Expr) any {
+	e, ok := expr.(*Spawn)
+	if !ok {
+		panic("should be spawn type expr")
+	}
+	e.call.Accept(o)
+	return e
+}
+
+func (o *Optimizer) VisitMatchExpr(expr Expr) any {
+	e, ok := expr.(*Match)
+	if !ok {
+		panic("should be match type expr")
+	}
+	e.subject = o.optimizeExpr(e.subject)
+	for _, arm := range e.arms {
+		for _, pattern := range arm.patterns {
+			o.optimizePattern(pattern)
+		}
+		if arm.body != nil {
+			arm.body = o.optimizeBody(arm.body)
+		} else {
+			arm.value = o.optimizeExpr(arm.value)
+		}
+	}
+	return e
+}
+
+func (o *Optimizer) VisitChainExpr(expr Expr) any {
+	e, ok := expr.(*Chain)
+	if !ok {
+		panic("should be chain type expr")
+	}
+	e.expression = o.optimizeExpr(e.expression)
+	return e
+}
+
+func (o *Optimizer) VisitExpressionStmt(stmt Stmt) any {
+	s, ok := stmt.(*Expression)
+	if !ok {
+		panic("should be expression type stmt")
+	}
+	s.expr = o.optimizeExpr(s.expr)
+	return s
+}
+
+func (o *Optimizer) VisitFunctionStmt(stmt Stmt) any {
+	s, ok := stmt.(*Function)
+	if !ok {
+		panic("should be function type stmt")
+	}
+	o.optimizeFunction(s)
+	return s
+}
+
+func (o *Optimizer) VisitPrintStmt(stmt Stmt) any {
+	s, ok := stmt.(*Print)
+	if !ok {
+		panic("should be print type stmt")
+	}
+	s.expr = o.optimizeExpr(s.expr)
+	return s
+}
+
+func (o *Optimizer) VisitReturnStmt(stmt Stmt) any {
+	s, ok := stmt.(*Return)
+	if !ok {
+		panic("should be return type stmt")
+	}
+	s.value = o.optimizeExpr(s.value)
+	return s
+}
+
+func (o *Optimizer) VisitVarStmt(stmt Stmt) any {
+	s, ok := stmt.(*Var)
+	if !ok {
+		panic("should be variable type stmt")
+	}
+	s.initializer = o.optimizeExpr(s.initializer)
+	return s
+}
+
+func (o *Optimizer) VisitDestructureStmt(stmt Stmt) any {
+	s, ok := stmt.(*Destructure)
+	if !ok {
+		panic("should be destructure type stmt")
+	}
+	o.optimizePattern(s.pattern)
+	s.initializer = o.optimizeExpr(s.initializer)
+	return s
+}
+
+func (o *Optimizer) VisitBlockStmt(stmt Stmt) any {
+	s, ok := stmt.(*Block)
+	if !ok {
+		panic("should be block type stmt")
+	}
+	s.statements = o.optimizeStmts(s.statements)
+	return s
+}
+
+func (o *Optimizer) VisitClassStmt(stmt Stmt) any {
+	s, ok := stmt.(*Class)
+	if !ok {
+		panic("should be class type stmt")
+	}
+	for _, method := range s.methods {
+		o.optimizeFunction(method)
+	}
+	return s
+}
+
+func (o *Optimizer) VisitTraitStmt(stmt Stmt) any {
+	s, ok := stmt.(*Trait)
+	if !ok {
+		panic("should be trait type stmt")
+	}
+	for _, method := range s.methods {
+		o.optimizeFunction(method)
+	}
+	return s
+}
+
+// VisitIfStmt keeps only the branch a constant condition selects.
+func (o *Optimizer) VisitIfStmt(stmt Stmt) any {
+	s, ok := stmt.(*If)
+	if !ok {
+		panic("should be if type stmt")
+	}
+	s.condition = o.optimizeExpr(s.condition)
+	condition, ok := s.condition.(*Literal)
+	if !ok {
+		s.thenBranch = o.optimizeBody(s.thenBranch)
+		s.elseBranch = o.optimizeStmt(s.elseBranch)
+		return s
+	}
+	if o.inter.isTruthy(condition.value) {
+		return o.optimizeStmt(s.thenBranch)
+	}
+	return o.optimizeStmt(s.elseBranch)
+}
+
+func (o *Optimizer) VisitWhileStmt(stmt Stmt) any {
+	s, ok := stmt.(*While)
+	if !ok {
+		panic("should be while type stmt")
+	}
+	s.condition = o.optimizeExpr(s.condition)
+	if condition, ok := s.condition.(*Literal); ok && !o.inter.isTruthy(condition.value) {
+		return nil
+	}
+	s.body = o.optimizeBody(s.body)
+	return s
+}
+
+func (o *Optimizer) VisitYieldStmt(stmt Stmt) any {
+	s, ok := stmt.(*Yield)
+	if !ok {
+		panic("should be yield type stmt")
+	}
+	s.value = o.optimizeExpr(s.value)
+	return s
+}
+
+func (o *Optimizer) VisitEnumStmt(stmt Stmt) any {
+	return stmt
+}
+
+func (o *Optimizer) VisitValuePattern(pattern Pattern) any {
+	p, ok := pattern.(*Value)
+	if !ok {
+		panic("should be value type pattern")
+	}
+	p.value = o.optimizeExpr(p.value)
+	return p
+}
+
+func (o *Optimizer) VisitBindPattern(pattern Pattern) any {
+	return pattern
+}
+
+func (o *Optimizer) VisitSequencePattern(pattern Pattern) any {
+	p, ok := pattern.(*Sequence)
+	if !ok {
+		panic("should be sequence type pattern")
+	}
+	for _, el := range p.elements {
+		o.optimizePattern(el)
+	}
+	return p
+}
+
+func (o *Optimizer) VisitInstancePattern(pattern Pattern) any {
+	p, ok := pattern.(*Instance)
+	if !ok {
+		panic("should be instance type pattern")
+	}
+	for _, sub := range p.patterns {
+		o.optimizePattern(sub)
+	}
+	return p
+}
+
+func (o *Optimizer) VisitFieldsPattern(pattern Pattern) any {
+	p, ok := pattern.(*Fields)
+	if !ok {
+		panic("should be fields type pattern")
+	}
+	for _, sub := range p.patterns {
+		o.optimizePattern(sub)
+	}
+	return p
+}
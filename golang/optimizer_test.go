@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimize(t *testing.T) {
+	inter := NewInterpreter()
+	defer inter.Close()
+	source := `var day = 60 * 60 * 24;
+var s = "a" + "b" + 1;
+var t = (1 + 2) * -3;
+var u = true and "x";
+var v = nil ?? "d";
+var w = false or day;
+var big = 9007199254740992 * 4;
+if (false) print "never"; else print "else";
+if (1 > 2) { print "no"; }
+while (false) print "nope";
+fun f() { return 1; print "dead"; var z = 2; }
+fun g(x) { if (!true) return 0; return x + 2 * 3; }
+print "a" - 1;
+`
+	want := `(var day 86400)
+(var s "ab1")
+(var t -9)
+(var u "x")
+(var v "d")
+(var w day)
+(var big 36028797018963968n)
+(print "else")
+(fun f() (return 1))
+(fun g(x) (return (+ x 6)))
+(print (- "a" 1))
+`
+	stmts := NewOptimizer(inter).Optimize(parse(t, inter, source))
+	assert.Equal(t, want, (&AstPrinter{}).Print(stmts))
+}
+
+// TestFoldKeepsFailingExpressions checks an expression failing at compile
+// time is left to fail when it runs, without failing the rest of the line.
+func TestFoldKeepsFailingExpressions(t *testing.T) {
+	inter = NewInterpreter()
+	defer inter.Close()
+	hadError = false
+	out := captureOutput(t, func() {
+		run(`fun f() { return -"a"; } 1 + 2;`, true)
+	})
+	assert.Equal(t, "3\n", out)
+	assert.False(t, hadError)
+	out = captureOutput(t, func() {
+		run(`f();`, true)
+	})
+	assert.Equal(t, "[line 1] message: should be float type\n\n", out)
+	hadError = false
+}
+
+func TestFoldOutsideOfARun(t *testing.T) {
+	inter := NewInterpreter()
+	defer inter.Close()
+	parser := &Parser{tokens: NewScanner(`print "a" + "b";`).ScanTokens()}
+	stmts := NewOptimizer(inter).Optimize(parser.ParseStmts())
+	assert.Equal(t, MemoryStats{}, inter.MemoryStats())
+	captureOutput(t, func() {
+		inter.Execute(stmts)
+	})
+	assert.Equal(t, MemoryStats{}, inter.MemoryStats(), "the string was made by the optimizer")
+}
+
+// TestFoldKeepsLines checks the folded literals are compiled with the line
+// of their operator.
+func TestFoldKeepsLines(t *testing.T) {
+	inter := NewInterpreter()
+	defer inter.Close()
+	stmts := NewOptimizer(inter).Optimize(parse(t, inter, "print\n  1 +\n  2;"))
+	lines := Compile(stmts, nil, false).chunk.lines
+	assert.Equal(t, 2, lines[0])
+}
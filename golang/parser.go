@@ -5,6 +5,8 @@ import "fmt"
 type Parser struct {
 	tokens  []*Token
 	current int
+	// set when a yield statement is met in the function body being parsed
+	yields bool
 }
 
 func (p *Parser) Parse() (expr Expr) {
@@ -34,6 +36,13 @@ func (p *Parser) check(typ TokenType) bool {
 	return p.peek().typ == typ
 }
 
+func (p *Parser) checkNext(typ TokenType) bool {
+	if p.isAtEnd() || p.current+1 >= len(p.tokens) {
+		return false
+	}
+	return p.tokens[p.current+1].typ == typ
+}
+
 func (p *Parser) isAtEnd() bool {
 	return p.peek().typ == EOF
 }
@@ -74,6 +83,17 @@ func (p *Parser) and() Expr {
 	return expr
 }
 
+// coalesce parses 'a ?? b', which is b when a is nil.
+func (p *Parser) coalesce() Expr {
+	expr := p.or()
+	for p.match(QUESTION_QUESTION) {
+		operator := p.previous()
+		right := p.or()
+		expr = &Logical{expr, operator, right}
+	}
+	return expr
+}
+
 func (p *Parser) or() Expr {
 	expr := p.and()
 	for p.match(OR) {
@@ -85,7 +105,7 @@ func (p *Parser) or() Expr {
 }
 
 func (p *Parser) assignment() Expr {
-	expr := p.or()
+	expr := p.coalesce()
 	if p.match(EQUAL) {
 		equals := p.previous()
 		value := p.assignment()
@@ -106,7 +126,7 @@ func (p *Parser) expression() Expr {
 
 func (p *Parser) comparison() Expr {
 	expr := p.term()
-	for p.match(GREATER, GREATER_EQUAL, LESS, LESS_EQUAL) {
+	for p.match(GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, INSTANCEOF) {
 		operator := p.previous()
 		right := p.term()
 		expr = &Binary{expr, operator, right}
@@ -140,39 +160,79 @@ func (p *Parser) unary() Expr {
 		right := p.unary()
 		return &Unary{operator, right}
 	}
+	if p.match(SPAWN) {
+		keyword := p.previous()
+		call, ok := p.call().(*Call)
+		if !ok {
+			Panic(keyword.line, "Expect function call after 'spawn'.")
+		}
+		return &Spawn{keyword, call}
+	}
 	return p.call()
 }
 
 func (p *Parser) call() Expr {
 	expr := p.primary()
+	optional := false
 	for {
 		if p.match(LEFT_PAREN) {
 			expr = p.finishCall(expr)
 		} else if p.match(DOT) {
-			name := p.consume(IDENTIFIER, "Expect property name after '.'.")
-			expr = &Get{expr, name}
+			expr = &Get{expr, p.propertyName(), false}
+		} else if p.match(QUESTION_DOT) {
+			expr = &Get{expr, p.propertyName(), true}
+			optional = true
 		} else {
 			break
 		}
 	}
+	// a nil before '?.' short-circuits the rest of the chain
+	if optional {
+		return &Chain{expr}
+	}
 	return expr
 }
 
+// propertyName parses the name after '.', which may be a private one.
+func (p *Parser) propertyName() *Token {
+	if p.match(IDENTIFIER, PRIVATE_IDENTIFIER) {
+		return p.previous()
+	}
+	Panic(p.peek().line, "Expect property name after '.'.")
+	return nil
+}
+
 func (p *Parser) finishCall(callee Expr) Expr {
 	args := []Expr{}
+	// names[k] is the parameter name of a keyword argument, nil when the
+	// argument is positional.
+	var names []*Token
+	keyword := false
 	if !p.check(RIGHT_PAREN) {
 		for {
 			if len(args) >= 255 {
 				Panic(p.peek().line, "Can't have more than 255 arguments.")
 			}
+			var name *Token
+			if p.check(IDENTIFIER) && p.checkNext(COLON) {
+				name = p.advance()
+				p.advance()
+				keyword = true
+			} else if keyword {
+				Panic(p.peek().line, "Positional argument can't follow keyword argument.")
+			}
 			args = append(args, p.expression())
+			names = append(names, name)
 			if !p.match(COMMA) {
 				break
 			}
 		}
 	}
 	paren := p.consume(RIGHT_PAREN, "Expect ')' after arguments.")
-	return &Call{callee, paren, args}
+	if !keyword {
+		names = nil
+	}
+	return &Call{callee, paren, args, names}
 }
 
 func (p *Parser) primary() Expr {
@@ -186,16 +246,16 @@ func (p *Parser) primary() Expr {
 		return &This{p.previous()}
 	}
 	if p.match(FALSE) {
-		return &Literal{false}
+		return &Literal{false, p.previous().line}
 	}
 	if p.match(TRUE) {
-		return &Literal{true}
+		return &Literal{true, p.previous().line}
 	}
 	if p.match(NIL) {
-		return &Literal{nil}
+		return &Literal{nil, p.previous().line}
 	}
 	if p.match(NUMBER, STRING) {
-		return &Literal{p.previous().literal}
+		return &Literal{p.previous().literal, p.previous().line}
 	}
 	if p.match(IDENTIFIER) {
 		return &Variable{p.previous()}
@@ -205,6 +265,9 @@ func (p *Parser) primary() Expr {
 		p.consume(RIGHT_PAREN, "Expect ')' after expression.")
 		return &Grouping{expression}
 	}
+	if p.match(MATCH) {
+		return p.matchExpression(false)
+	}
 	Panic(p.peek().line, "Expect expression.")
 	return nil
 }
@@ -224,7 +287,7 @@ func (p *Parser) synchronize() {
 			return
 		}
 		switch p.peek().typ {
-		case CLASS, FUN, VAR, FOR, IF, WHILE, PRINT, RETURN:
+		case CLASS, ENUM, TRAIT, FUN, VAR, CONST, FOR, IF, MATCH, WHILE, PRINT, RETURN, YIELD:
 			return
 		default:
 			p.advance()
@@ -256,12 +319,21 @@ func (p *Parser) declaration() Stmt {
 	if p.match(CLASS) {
 		return p.classDeclaration()
 	}
+	if p.match(ENUM) {
+		return p.enumDeclaration()
+	}
+	if p.match(TRAIT) {
+		return p.traitDeclaration()
+	}
 	if p.match(FUN) {
 		return p.function("function")
 	}
 	if p.match(VAR) {
 		return p.varDeclaration()
 	}
+	if p.match(CONST) {
+		return p.constDeclaration()
+	}
 	return p.statement()
 }
 
@@ -274,46 +346,192 @@ func (p *Parser) classDeclaration() Stmt {
 		superclass = &Variable{p.previous()}
 	}
 
+	traits := p.traitList()
+
 	p.consume(LEFT_BRACE, "Expect '{' before class body.")
 
 	var methods []*Function
+	var fields, fieldTypes []*Token
 	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		// 'name: Type;' declares a field for the type checker
+		if (p.check(IDENTIFIER) || p.check(PRIVATE_IDENTIFIER)) && p.checkNext(COLON) {
+			fields = append(fields, p.advance())
+			p.advance()
+			fieldTypes = append(fieldTypes, p.typeAnnotation())
+			p.consume(SEMICOLON, "Expect ';' after field declaration.")
+			continue
+		}
 		methods = append(methods, p.function("method").(*Function))
 	}
 	p.consume(RIGHT_BRACE, "Expect '}' after class body.")
-	return &Class{name, superclass, methods}
+	return &Class{name, superclass, methods, traits, fields, fieldTypes}
+}
+
+// typeAnnotation parses the type after ':', types are only looked at by the
+// type checker.
+func (p *Parser) typeAnnotation() *Token {
+	return p.consume(IDENTIFIER, "Expect type name.")
+}
+
+func (p *Parser) traitDeclaration() Stmt {
+	name := p.consume(IDENTIFIER, "Expect trait name.")
+	traits := p.traitList()
+
+	p.consume(LEFT_BRACE, "Expect '{' before trait body.")
+
+	var methods []*Function
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		methods = append(methods, p.function("method").(*Function))
+	}
+	p.consume(RIGHT_BRACE, "Expect '}' after trait body.")
+	return &Trait{name, traits, methods}
+}
+
+// traitList parses the optional 'with T1, T2' of classes and traits.
+func (p *Parser) traitList() []*Variable {
+	var traits []*Variable
+	if p.match(WITH) {
+		for {
+			traits = append(traits, &Variable{p.consume(IDENTIFIER, "Expect trait name.")})
+			if !p.match(COMMA) {
+				break
+			}
+		}
+	}
+	return traits
+}
+
+func (p *Parser) enumDeclaration() Stmt {
+	name := p.consume(IDENTIFIER, "Expect enum name.")
+	p.consume(LEFT_BRACE, "Expect '{' before enum body.")
+	var members []*Token
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		members = append(members, p.consume(IDENTIFIER, "Expect enum member name."))
+		if !p.match(COMMA) {
+			break
+		}
+	}
+	p.consume(RIGHT_BRACE, "Expect '}' after enum body.")
+	return &Enum{name, members}
 }
 
 func (p *Parser) function(kind string) Stmt {
-	name := p.consume(IDENTIFIER, fmt.Sprintf("Expect kind %s name.", kind))
+	var name *Token
+	if kind == "method" && p.match(PRIVATE_IDENTIFIER) {
+		name = p.previous()
+	} else {
+		name = p.consume(IDENTIFIER, fmt.Sprintf("Expect kind %s name.", kind))
+	}
 	p.consume(LEFT_PAREN, fmt.Sprintf("Expect '(' after %s name.", kind))
 
 	var parameters []*Token
+	var defaults []Expr
+	var patterns []Pattern
+	var paramTypes []*Token
+	var rest, restType *Token
+	hasDefault := false
 	if !p.check(RIGHT_PAREN) {
 		for {
 			if len(parameters) >= 255 {
 				Panic(p.peek().line, "Can't have more than 255 parameters.")
 			}
-			parameters = append(parameters, p.consume(IDENTIFIER, "Expect parameter name."))
+			if p.match(ELLIPSIS) {
+				rest = p.consume(IDENTIFIER, "Expect rest parameter name after '...'.")
+				// the annotation is the type of each argument the list holds
+				if p.match(COLON) {
+					restType = p.typeAnnotation()
+				}
+				if p.check(COMMA) {
+					Panic(p.peek().line, "Rest parameter must be the last parameter.")
+				}
+				break
+			}
+			var param *Token
+			var pattern Pattern
+			if p.check(LEFT_BRACKET) || p.check(LEFT_BRACE) {
+				// the argument is bound to a name scripts can't refer to,
+				// then destructured into the names of the pattern.
+				param = &Token{typ: IDENTIFIER, lexeme: fmt.Sprintf("<param %d>", len(parameters)), line: p.peek().line}
+				pattern = p.bindingPattern()
+			} else {
+				param = p.consume(IDENTIFIER, "Expect parameter name.")
+			}
+			var paramType *Token
+			if p.match(COLON) {
+				paramType = p.typeAnnotation()
+			}
+			var value Expr
+			if p.match(EQUAL) {
+				value = p.expression()
+				hasDefault = true
+			} else if hasDefault {
+				Panic(param.line, "Parameter without default value can't follow one with default value.")
+			}
+			parameters = append(parameters, param)
+			defaults = append(defaults, value)
+			patterns = append(patterns, pattern)
+			paramTypes = append(paramTypes, paramType)
 			if !p.match(COMMA) {
 				break
 			}
 		}
 	}
 	p.consume(RIGHT_PAREN, "Expect ')' after parameters.")
+	var returnType *Token
+	if p.match(COLON) {
+		returnType = p.typeAnnotation()
+	}
 	p.consume(LEFT_BRACE, fmt.Sprintf("Expect '{' before %s body.", kind))
+
+	// a function containing yield becomes a generator, nested functions
+	// don't count since they are generators on their own.
+	enclosingYields := p.yields
+	p.yields = false
 	body := p.block()
-	return &Function{name, parameters, body}
+	generator := p.yields
+	p.yields = enclosingYields
+	return &Function{name, parameters, body, generator, defaults, rest, patterns, paramTypes, returnType, restType}
 }
 
 func (p *Parser) varDeclaration() Stmt {
+	if p.check(LEFT_BRACKET) || p.check(LEFT_BRACE) {
+		return p.destructuring(false)
+	}
 	name := p.consume(IDENTIFIER, "Expect variable name.")
+	var annotation *Token
+	if p.match(COLON) {
+		annotation = p.typeAnnotation()
+	}
 	var initializer Expr
 	if p.match(EQUAL) {
 		initializer = p.expression()
 	}
 	p.consume(SEMICOLON, "Expect ';' after variable declaration.")
-	return &Var{name, initializer}
+	return &Var{name, initializer, false, annotation}
+}
+
+func (p *Parser) constDeclaration() Stmt {
+	if p.check(LEFT_BRACKET) || p.check(LEFT_BRACE) {
+		return p.destructuring(true)
+	}
+	name := p.consume(IDENTIFIER, "Expect constant name.")
+	var annotation *Token
+	if p.match(COLON) {
+		annotation = p.typeAnnotation()
+	}
+	p.consume(EQUAL, "Expect '=' after constant name.")
+	initializer := p.expression()
+	p.consume(SEMICOLON, "Expect ';' after constant declaration.")
+	return &Var{name, initializer, true, annotation}
+}
+
+func (p *Parser) destructuring(constant bool) Stmt {
+	keyword := p.previous()
+	pattern := p.bindingPattern()
+	p.consume(EQUAL, "Expect '=' after destructuring pattern.")
+	initializer := p.expression()
+	p.consume(SEMICOLON, "Expect ';' after variable declaration.")
+	return &Destructure{keyword, pattern, initializer, constant}
 }
 
 func (p *Parser) returnStatement() Stmt {
@@ -326,6 +544,17 @@ func (p *Parser) returnStatement() Stmt {
 	return &Return{keyword, value}
 }
 
+func (p *Parser) yieldStatement() Stmt {
+	keyword := p.previous()
+	var value Expr
+	if !p.check(SEMICOLON) {
+		value = p.expression()
+	}
+	p.consume(SEMICOLON, "Expect ';' after yield value.")
+	p.yields = true
+	return &Yield{keyword, value}
+}
+
 func (p *Parser) statement() Stmt {
 	if p.match(PRINT) {
 		return p.printStatement()
@@ -333,6 +562,9 @@ func (p *Parser) statement() Stmt {
 	if p.match(RETURN) {
 		return p.returnStatement()
 	}
+	if p.match(YIELD) {
+		return p.yieldStatement()
+	}
 	if p.match(IF) {
 		return p.ifStatement()
 	}
@@ -345,10 +577,14 @@ func (p *Parser) statement() Stmt {
 	if p.match(LEFT_BRACE) {
 		return &Block{p.block()}
 	}
+	if p.match(MATCH) {
+		return &Expression{p.matchExpression(true)}
+	}
 	return p.exprStatement()
 }
 
 func (p *Parser) forStatement() Stmt {
+	keyword := p.previous()
 	p.consume(LEFT_PAREN, "Expect '(' after 'for'.")
 
 	var initializer Stmt
@@ -378,7 +614,7 @@ func (p *Parser) forStatement() Stmt {
 		body = &Block{[]Stmt{body, &Expression{increment}}}
 	}
 	if condition == nil {
-		condition = &Literal{true}
+		condition = &Literal{true, keyword.line}
 	}
 	body = &While{condition, body}
 	if initializer != nil {
@@ -428,3 +664,150 @@ func (p *Parser) exprStatement() Stmt {
 	p.consume(SEMICOLON, "Expect ';' after expression.")
 	return &Expression{expr}
 }
+
+// matchExpression parses the cases of a match, the arms of the statement
+// form are statements while the ones of the expression form are expressions
+// followed by ';'.
+func (p *Parser) matchExpression(statement bool) Expr {
+	keyword := p.previous()
+	p.consume(LEFT_PAREN, "Expect '(' after 'match'.")
+	subject := p.expression()
+	p.consume(RIGHT_PAREN, "Expect ')' after match subject.")
+	p.consume(LEFT_BRACE, "Expect '{' before match cases.")
+
+	var arms []*MatchArm
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		if len(arms) != 0 && arms[len(arms)-1].patterns == nil {
+			Panic(p.peek().line, "Default case must be the last case.")
+		}
+		arm := &MatchArm{}
+		if p.match(DEFAULT) {
+			arm.keyword = p.previous()
+		} else {
+			arm.keyword = p.consume(CASE, "Expect 'case' or 'default' in match body.")
+			for {
+				arm.patterns = append(arm.patterns, p.pattern())
+				if !p.match(COMMA) {
+					break
+				}
+			}
+		}
+		p.consume(ARROW, "Expect '=>' after case patterns.")
+		if statement {
+			arm.body = p.statement()
+		} else {
+			arm.value = p.expression()
+			p.consume(SEMICOLON, "Expect ';' after case value.")
+		}
+		arms = append(arms, arm)
+	}
+	p.consume(RIGHT_BRACE, "Expect '}' after match cases.")
+	return &Match{keyword, subject, arms}
+}
+
+// sequencePattern parses the elements of '[...]', element parses each one.
+func (p *Parser) sequencePattern(element func() Pattern) Pattern {
+	bracket := p.previous()
+	var elements []Pattern
+	var rest *Token
+	for !p.check(RIGHT_BRACKET) && !p.isAtEnd() {
+		if p.match(ELLIPSIS) {
+			rest = p.consume(IDENTIFIER, "Expect rest binding name after '...'.")
+			break
+		}
+		elements = append(elements, element())
+		if !p.match(COMMA) {
+			break
+		}
+	}
+	p.consume(RIGHT_BRACKET, "Expect ']' after list pattern.")
+	return &Sequence{bracket, elements, rest}
+}
+
+// fieldsPattern parses the fields of '{...}', a field without ': pattern'
+// binds a variable of the same name.
+func (p *Parser) fieldsPattern(element func() Pattern) Pattern {
+	brace := p.previous()
+	var names []*Token
+	var patterns []Pattern
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		name := p.consume(IDENTIFIER, "Expect field name.")
+		var pattern Pattern = &Bind{name}
+		if p.match(COLON) {
+			pattern = element()
+		}
+		names = append(names, name)
+		patterns = append(patterns, pattern)
+		if !p.match(COMMA) {
+			break
+		}
+	}
+	p.consume(RIGHT_BRACE, "Expect '}' after fields pattern.")
+	return &Fields{brace, names, patterns}
+}
+
+// bindingPattern parses the patterns allowed by destructuring, which only
+// bind names.
+func (p *Parser) bindingPattern() Pattern {
+	if p.match(LEFT_BRACKET) {
+		return p.sequencePattern(p.bindingPattern)
+	}
+	if p.match(LEFT_BRACE) {
+		return p.fieldsPattern(p.bindingPattern)
+	}
+	return &Bind{p.consume(IDENTIFIER, "Expect variable name.")}
+}
+
+func (p *Parser) pattern() Pattern {
+	if p.match(LEFT_BRACKET) {
+		return p.sequencePattern(p.pattern)
+	}
+	if p.match(LEFT_BRACE) {
+		return p.fieldsPattern(p.pattern)
+	}
+	if p.match(FALSE) {
+		return &Value{&Literal{false, p.previous().line}}
+	}
+	if p.match(TRUE) {
+		return &Value{&Literal{true, p.previous().line}}
+	}
+	if p.match(NIL) {
+		return &Value{&Literal{nil, p.previous().line}}
+	}
+	if p.match(NUMBER, STRING) {
+		return &Value{&Literal{p.previous().literal, p.previous().line}}
+	}
+	if p.match(MINUS) {
+		number := p.consume(NUMBER, "Expect number after '-' in pattern.")
+		return &Value{&Literal{-number.literal.(float64), number.line}}
+	}
+	name := p.consume(IDENTIFIER, "Expect pattern.")
+	if p.check(DOT) {
+		// a qualified name such as an enum member is compared by value
+		var expr Expr = &Variable{name}
+		for p.match(DOT) {
+			expr = &Get{expr, p.consume(IDENTIFIER, "Expect property name after '.'."), false}
+		}
+		return &Value{expr}
+	}
+	if p.match(LEFT_PAREN) {
+		paren := p.previous()
+		var fields []*Token
+		var patterns []Pattern
+		for !p.check(RIGHT_PAREN) && !p.isAtEnd() {
+			var field *Token
+			if p.check(IDENTIFIER) && p.checkNext(COLON) {
+				field = p.advance()
+				p.advance()
+			}
+			fields = append(fields, field)
+			patterns = append(patterns, p.pattern())
+			if !p.match(COMMA) {
+				break
+			}
+		}
+		p.consume(RIGHT_PAREN, "Expect ')' after instance pattern.")
+		return &Instance{&Variable{name}, paren, fields, patterns}
+	}
+	return &Bind{name}
+}
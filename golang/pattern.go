@@ -0,0 +1,62 @@
+// Code generated by glox-gen. DO NOT EDIT.
+
+package main
+
+type Pattern interface {
+	Accept(PatternVisitor) any
+}
+
+type PatternVisitor interface {
+	VisitValuePattern(Pattern) any
+	VisitBindPattern(Pattern) any
+	VisitSequencePattern(Pattern) any
+	VisitInstancePattern(Pattern) any
+	VisitFieldsPattern(Pattern) any
+}
+
+type Value struct {
+	value Expr
+}
+
+func (e *Value) Accept(v PatternVisitor) (ret any) {
+	return v.VisitValuePattern(e)
+}
+
+type Bind struct {
+	name *Token
+}
+
+func (e *Bind) Accept(v PatternVisitor) (ret any) {
+	return v.VisitBindPattern(e)
+}
+
+type Sequence struct {
+	bracket  *Token
+	elements []Pattern
+	rest     *Token
+}
+
+func (e *Sequence) Accept(v PatternVisitor) (ret any) {
+	return v.VisitSequencePattern(e)
+}
+
+type Instance struct {
+	class    *Variable
+	paren    *Token
+	fields   []*Token
+	patterns []Pattern
+}
+
+func (e *Instance) Accept(v PatternVisitor) (ret any) {
+	return v.VisitInstancePattern(e)
+}
+
+type Fields struct {
+	brace    *Token
+	names    []*Token
+	patterns []Pattern
+}
+
+func (e *Fields) Accept(v PatternVisitor) (ret any) {
+	return v.VisitFieldsPattern(e)
+}
@@ -1,73 +1,420 @@
 package main
 
-//type AstPrinter struct{}
-//
-//func parenthesize(name string, expr ...any) string {
-//	var b strings.Builder
-//	b.Grow(len(name) + 5*len(expr))
-//	b.WriteString("(")
-//	b.WriteString(name)
-//	for _, e := range expr {
-//		b.WriteString(" ")
-//		b.WriteString(fmt.Sprintf("%v", e))
-//	}
-//	b.WriteString(")")
-//	return b.String()
-//}
-//
-//func (ap *AstPrinter) VisitUnaryExpr(e Expr) any {
-//	unary, ok := e.(*Unary)
-//	if !ok {
-//		panic("should be unary type")
-//	}
-//	return parenthesize(unary.operator.lexeme, unary.right.Accept(ap))
-//}
-//
-//func (ap *AstPrinter) VisitGetExpr(e Expr) any {
-//	return nil
-//}
-//
-//func (ap *AstPrinter) VisitSetExpr(e Expr) any {
-//	return nil
-//}
-//
-//func (ap *AstPrinter) VisitBinaryExpr(e Expr) any {
-//	binary, ok := e.(*Binary)
-//	if !ok {
-//		panic("should be binary type")
-//	}
-//
-//	return parenthesize(binary.operator.lexeme, binary.left.Accept(ap), binary.right.Accept(ap))
-//}
-//
-//func (ap *AstPrinter) VisitCallExpr(e Expr) any {
-//	return nil
-//}
-//
-//func (ap *AstPrinter) VisitAssignExpr(e Expr) any {
-//	return nil
-//}
-//
-//func (ap *AstPrinter) VisitGroupingExpr(e Expr) any {
-//	grouping, ok := e.(*Grouping)
-//	if !ok {
-//		panic("should be grouping type")
-//	}
-//	return parenthesize("group", grouping.expression.Accept(ap))
-//}
-//
-//func (ap *AstPrinter) VisitLiteralExpr(e Expr) any {
-//	literal, ok := e.(*Literal)
-//	if !ok {
-//		panic("should be literal type")
-//	}
-//	return literal.value
-//}
-//
-//func (ap *AstPrinter) VisitVariableExpr(e Expr) any {
-//	return nil
-//}
-//
-//func (ap *AstPrinter) VisitLogicalExpr(e Expr) any {
-//	return nil
-//}
+import (
+	"fmt"
+	"strings"
+)
+
+// AstPrinter prints the syntax tree as s-expressions, one line per
+// statement, used to show the tree the optimizer produces.
+type AstPrinter struct{}
+
+// Print returns the statements, one per line.
+func (ap *AstPrinter) Print(stmts []Stmt) string {
+	var b strings.Builder
+	for _, stmt := range stmts {
+		b.WriteString(ap.stmt(stmt))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func parenthesize(name string, parts ...any) string {
+	var b strings.Builder
+	b.Grow(len(name) + 5*len(parts))
+	b.WriteString("(")
+	b.WriteString(name)
+	for _, part := range parts {
+		b.WriteString(" ")
+		b.WriteString(fmt.Sprintf("%v", part))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func (ap *AstPrinter) expr(expr Expr) string {
+	if expr == nil {
+		return "nil"
+	}
+	return expr.Accept(ap).(string)
+}
+
+func (ap *AstPrinter) stmt(stmt Stmt) string {
+	return stmt.Accept(ap).(string)
+}
+
+func (ap *AstPrinter) stmts(stmts []Stmt) []any {
+	parts := make([]any, len(stmts))
+	for k, stmt := range stmts {
+		parts[k] = ap.stmt(stmt)
+	}
+	return parts
+}
+
+func (ap *AstPrinter) pattern(pattern Pattern) string {
+	return pattern.Accept(ap).(string)
+}
+
+func (ap *AstPrinter) function(keyword string, fn *Function) string {
+	params := make([]string, len(fn.params))
+	for k, param := range fn.params {
+		params[k] = param.lexeme
+		if fn.patterns != nil && fn.patterns[k] != nil {
+			params[k] = ap.pattern(fn.patterns[k])
+		}
+		if fn.defaults[k] != nil {
+			params[k] += " = " + ap.expr(fn.defaults[k])
+		}
+	}
+	if fn.rest != nil {
+		params = append(params, "..."+fn.rest.lexeme)
+	}
+	name := fn.name.lexeme + "(" + strings.Join(params, ", ") + ")"
+	return parenthesize(keyword, append([]any{name}, ap.stmts(fn.body)...)...)
+}
+
+func (ap *AstPrinter) VisitBinaryExpr(expr Expr) any {
+	e, ok := expr.(*Binary)
+	if !ok {
+		panic("should be binary type expr")
+	}
+	return parenthesize(e.operator.lexeme, ap.expr(e.left), ap.expr(e.right))
+}
+
+func (ap *AstPrinter) VisitGroupingExpr(expr Expr) any {
+	e, ok := expr.(*Grouping)
+	if !ok {
+		panic("should be grouping type expr")
+	}
+	return parenthesize("group", ap.expr(e.expression))
+}
+
+func (ap *AstPrinter) VisitLiteralExpr(expr Expr) any {
+	e, ok := expr.(*Literal)
+	if !ok {
+		panic("should be literal type expr")
+	}
+	return Repr(e.value)
+}
+
+func (ap *AstPrinter) VisitUnaryExpr(expr Expr) any {
+	e, ok := expr.(*Unary)
+	if !ok {
+		panic("should be unary type expr")
+	}
+	return parenthesize(e.operator.lexeme, ap.expr(e.right))
+}
+
+func (ap *AstPrinter) VisitVariableExpr(expr Expr) any {
+	e, ok := expr.(*Variable)
+	if !ok {
+		panic("should be variable type expr")
+	}
+	return e.name.lexeme
+}
+
+func (ap *AstPrinter) VisitAssignExpr(expr Expr) any {
+	e, ok := expr.(*Assign)
+	if !ok {
+		panic("should be assign type expr")
+	}
+	return parenthesize("=", e.name.lexeme, ap.expr(e.value))
+}
+
+func (ap *AstPrinter) VisitLogicalExpr(expr Expr) any {
+	e, ok := expr.(*Logical)
+	if !ok {
+		panic("should be logical type expr")
+	}
+	return parenthesize(e.operator.lexeme, ap.expr(e.left), ap.expr(e.right))
+}
+
+func (ap *AstPrinter) VisitCallExpr(expr Expr) any {
+	e, ok := expr.(*Call)
+	if !ok {
+		panic("should be call type expr")
+	}
+	parts := []any{ap.expr(e.callee)}
+	for k, arg := range e.arguments {
+		if e.names != nil && e.names[k] != nil {
+			parts = append(parts, e.names[k].lexeme+": "+ap.expr(arg))
+			continue
+		}
+		parts = append(parts, ap.expr(arg))
+	}
+	return parenthesize("call", parts...)
+}
+
+func (ap *AstPrinter) VisitGetExpr(expr Expr) any {
+	e, ok := expr.(*Get)
+	if !ok {
+		panic("should be get type expr")
+	}
+	if e.optional {
+		return parenthesize("?.", ap.expr(e.object), e.name.lexeme)
+	}
+	return parenthesize(".", ap.expr(e.object), e.name.lexeme)
+}
+
+func (ap *AstPrinter) VisitSetExpr(expr Expr) any {
+	e, ok := expr.(*Set)
+	if !ok {
+		panic("should be set type expr")
+	}
+	return parenthesize("=", parenthesize(".", ap.expr(e.object), e.name.lexeme), ap.expr(e.value))
+}
+
+func (ap *AstPrinter) VisitSuperExpr(expr Expr) any {
+	e, ok := expr.(*Super)
+	if !ok {
+		panic("should be super type expr")
+	}
+	return parenthesize("super", e.method.lexeme)
+}
+
+func (ap *AstPrinter) VisitThisExpr(expr Expr) any {
+	return "this"
+}
+
+func (ap *AstPrinter) VisitSpawnExpr(expr Expr) any {
+	e, ok := expr.(*Spawn)
+	if !ok {
+		panic("should be spawn type expr")
+	}
+	return parenthesize("spawn", ap.expr(e.call))
+}
+
+func (ap *AstPrinter) VisitMatchExpr(expr Expr) any {
+	e, ok := expr.(*Match)
+	if !ok {
+		panic("should be match type expr")
+	}
+	parts := []any{ap.expr(e.subject)}
+	for _, arm := range e.arms {
+		var armParts []any
+		name := "default"
+		if arm.patterns != nil {
+			name = "case"
+			for _, pattern := range arm.patterns {
+				armParts = append(armParts, ap.pattern(pattern))
+			}
+		}
+		if arm.body != nil {
+			armParts = append(armParts, "=>", ap.stmt(arm.body))
+		} else {
+			armParts = append(armParts, "=>", ap.expr(arm.value))
+		}
+		parts = append(parts, parenthesize(name, armParts...))
+	}
+	return parenthesize("match", parts...)
+}
+
+func (ap *AstPrinter) VisitChainExpr(expr Expr) any {
+	e, ok := expr.(*Chain)
+	if !ok {
+		panic("should be chain type expr")
+	}
+	return ap.expr(e.expression)
+}
+
+func (ap *AstPrinter) VisitExpressionStmt(stmt Stmt) any {
+	s, ok := stmt.(*Expression)
+	if !ok {
+		panic("should be expression type stmt")
+	}
+	return parenthesize(";", ap.expr(s.expr))
+}
+
+func (ap *AstPrinter) VisitFunctionStmt(stmt Stmt) any {
+	s, ok := stmt.(*Function)
+	if !ok {
+		panic("should be function type stmt")
+	}
+	return ap.function("fun", s)
+}
+
+func (ap *AstPrinter) VisitPrintStmt(stmt Stmt) any {
+	s, ok := stmt.(*Print)
+	if !ok {
+		panic("should be print type stmt")
+	}
+	return parenthesize("print", ap.expr(s.expr))
+}
+
+func (ap *AstPrinter) VisitReturnStmt(stmt Stmt) any {
+	s, ok := stmt.(*Return)
+	if !ok {
+		panic("should be return type stmt")
+	}
+	if s.value == nil {
+		return "(return)"
+	}
+	return parenthesize("return", ap.expr(s.value))
+}
+
+func (ap *AstPrinter) VisitVarStmt(stmt Stmt) any {
+	s, ok := stmt.(*Var)
+	if !ok {
+		panic("should be variable type stmt")
+	}
+	keyword := "var"
+	if s.constant {
+		keyword = "const"
+	}
+	if s.initializer == nil {
+		return parenthesize(keyword, s.name.lexeme)
+	}
+	return parenthesize(keyword, s.name.lexeme, ap.expr(s.initializer))
+}
+
+func (ap *AstPrinter) VisitDestructureStmt(stmt Stmt) any {
+	s, ok := stmt.(*Destructure)
+	if !ok {
+		panic("should be destructure type stmt")
+	}
+	return parenthesize(s.keyword.lexeme, ap.pattern(s.pattern), ap.expr(s.initializer))
+}
+
+func (ap *AstPrinter) VisitBlockStmt(stmt Stmt) any {
+	s, ok := stmt.(*Block)
+	if !ok {
+		panic("should be block type stmt")
+	}
+	return parenthesize("block", ap.stmts(s.statements)...)
+}
+
+func (ap *AstPrinter) VisitClassStmt(stmt Stmt) any {
+	s, ok := stmt.(*Class)
+	if !ok {
+		panic("should be class type stmt")
+	}
+	parts := []any{s.name.lexeme}
+	if s.superclass != nil {
+		parts = append(parts, "< "+s.superclass.name.lexeme)
+	}
+	for _, trait := range s.traits {
+		parts = append(parts, "with "+trait.name.lexeme)
+	}
+	for _, field := range s.fields {
+		parts = append(parts, parenthesize("field", field.lexeme))
+	}
+	for _, method := range s.methods {
+		parts = append(parts, ap.function("method", method))
+	}
+	return parenthesize("class", parts...)
+}
+
+func (ap *AstPrinter) VisitTraitStmt(stmt Stmt) any {
+	s, ok := stmt.(*Trait)
+	if !ok {
+		panic("should be trait type stmt")
+	}
+	parts := []any{s.name.lexeme}
+	for _, trait := range s.traits {
+		parts = append(parts, "with "+trait.name.lexeme)
+	}
+	for _, method := range s.methods {
+		parts = append(parts, ap.function("method", method))
+	}
+	return parenthesize("trait", parts...)
+}
+
+func (ap *AstPrinter) VisitIfStmt(stmt Stmt) any {
+	s, ok := stmt.(*If)
+	if !ok {
+		panic("should be if type stmt")
+	}
+	if s.elseBranch == nil {
+		return parenthesize("if", ap.expr(s.condition), ap.stmt(s.thenBranch))
+	}
+	return parenthesize("if", ap.expr(s.condition), ap.stmt(s.thenBranch), ap.stmt(s.elseBranch))
+}
+
+func (ap *AstPrinter) VisitWhileStmt(stmt Stmt) any {
+	s, ok := stmt.(*While)
+	if !ok {
+		panic("should be while type stmt")
+	}
+	return parenthesize("while", ap.expr(s.condition), ap.stmt(s.body))
+}
+
+func (ap *AstPrinter) VisitYieldStmt(stmt Stmt) any {
+	s, ok := stmt.(*Yield)
+	if !ok {
+		panic("should be yield type stmt")
+	}
+	if s.value == nil {
+		return "(yield)"
+	}
+	return parenthesize("yield", ap.expr(s.value))
+}
+
+func (ap *AstPrinter) VisitEnumStmt(stmt Stmt) any {
+	s, ok := stmt.(*Enum)
+	if !ok {
+		panic("should be enum type stmt")
+	}
+	parts := []any{s.name.lexeme}
+	for _, member := range s.members {
+		parts = append(parts, member.lexeme)
+	}
+	return parenthesize("enum", parts...)
+}
+
+func (ap *AstPrinter) VisitValuePattern(pattern Pattern) any {
+	p, ok := pattern.(*Value)
+	if !ok {
+		panic("should be value type pattern")
+	}
+	return ap.expr(p.value)
+}
+
+func (ap *AstPrinter) VisitBindPattern(pattern Pattern) any {
+	p, ok := pattern.(*Bind)
+	if !ok {
+		panic("should be bind type pattern")
+	}
+	return p.name.lexeme
+}
+
+func (ap *AstPrinter) VisitSequencePattern(pattern Pattern) any {
+	p, ok := pattern.(*Sequence)
+	if !ok {
+		panic("should be sequence type pattern")
+	}
+	elements := make([]string, 0, len(p.elements)+1)
+	for _, el := range p.elements {
+		elements = append(elements, ap.pattern(el))
+	}
+	if p.rest != nil {
+		elements = append(elements, "..."+p.rest.lexeme)
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func (ap *AstPrinter) VisitInstancePattern(pattern Pattern) any {
+	p, ok := pattern.(*Instance)
+	if !ok {
+		panic("should be instance type pattern")
+	}
+	parts := make([]string, len(p.patterns))
+	for k, sub := range p.patterns {
+		parts[k] = ap.pattern(sub)
+		if p.fields[k] != nil {
+			parts[k] = p.fields[k].lexeme + ": " + parts[k]
+		}
+	}
+	return p.class.name.lexeme + "(" + strings.Join(parts, ", ") + ")"
+}
+
+func (ap *AstPrinter) VisitFieldsPattern(pattern Pattern) any {
+	p, ok := pattern.(*Fields)
+	if !ok {
+		panic("should be fields type pattern")
+	}
+	parts := make([]string, len(p.names))
+	for k, name := range p.names {
+		parts[k] = name.lexeme + ": " + ap.pattern(p.patterns[k])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// typeName returns the name type() reports for a value.
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case *big.Int:
+		return "bigint"
+	case *Decimal:
+		return "decimal"
+	case string:
+		return "string"
+	case *LoxClass, *ObjClass:
+		return "class"
+	case *LoxTrait, *ObjTrait:
+		return "trait"
+	case *LoxEnum:
+		return "enum"
+	case *LoxEnumMember:
+		return "enum member"
+	case *LoxInstance, *ObjInstance:
+		return "instance"
+	case *LoxList:
+		return "list"
+	case *LoxGenerator:
+		return "generator"
+	case *LoxTask:
+		return "task"
+	case *LoxChannel:
+		return "channel"
+	case Callable, *ObjClosure, *ObjBoundMethod:
+		return "function"
+	}
+	return "unknown"
+}
+
+// isInstance implements 'instanceof', classes match their instances and the
+// ones of their subclasses, traits the instances of classes including them
+// and enums their members.
+func (i *Interpreter) isInstance(value, class any, operator *Token) bool {
+	switch c := class.(type) {
+	case *LoxClass:
+		instance, ok := value.(*LoxInstance)
+		return ok && instance.loxClass.IsSubclassOf(c)
+	case *LoxTrait:
+		instance, ok := value.(*LoxInstance)
+		return ok && instance.loxClass.Includes(c)
+	case *LoxEnum:
+		member, ok := value.(*LoxEnumMember)
+		return ok && member.enum == c
+	}
+	Panic(operator.line, fmt.Sprintf("Right operand of 'instanceof' must be a class, trait or enum but got %v", Repr(class)))
+	return false
+}
+
+// instance is what the reflection builtins need from the instances of both
+// the tree walker and the VM.
+type instance interface {
+	Object
+	ClassName() string
+	Field(name string) (any, bool)
+	FieldNames() []string
+	MethodNames() []string
+	Set(name *Token, value any)
+	Freeze()
+}
+
+func instanceArg(fn string, v any, line int) instance {
+	instance, ok := v.(instance)
+	if !ok {
+		Panic(line, fmt.Sprintf("%s expects an instance but got %v", fn, Repr(v)))
+	}
+	return instance
+}
+
+// fieldNameArg checks the field name passed as a string, private fields
+// can't be reached by name.
+func fieldNameArg(fn string, v any, line int) *Token {
+	name, ok := v.(string)
+	if !ok {
+		Panic(line, fmt.Sprintf("%s expects a field name but got %v", fn, Repr(v)))
+	}
+	if strings.HasPrefix(name, "#") {
+		Panic(line, fmt.Sprintf("%s can't access private member '%s'", fn, name))
+	}
+	return &Token{typ: IDENTIFIER, lexeme: name, line: line}
+}
+
+func namesList(names []string) *LoxList {
+	values := make([]any, len(names))
+	for k, name := range names {
+		values[k] = name
+	}
+	return NewLoxList(values)
+}
+
+func injectReflection(i *Interpreter) {
+	i.env.Define("type", NewPrimitive(1, func(args []any) any {
+		return typeName(args[0])
+	}))
+	i.env.Define("className", NewPrimitive(1, func(args []any) any {
+		switch v := args[0].(type) {
+		case instance:
+			return v.ClassName()
+		case *LoxClass:
+			return v.name
+		case *ObjClass:
+			return v.name
+		}
+		return nil
+	}))
+	i.env.Define("fields", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		return namesList(instanceArg("fields", args[0], i.line).FieldNames())
+	}))
+	i.env.Define("methods", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		switch v := args[0].(type) {
+		case *LoxClass:
+			return namesList(v.MethodNames())
+		case *ObjClass:
+			return namesList(v.MethodNames())
+		case instance:
+			return namesList(v.MethodNames())
+		}
+		Panic(i.line, fmt.Sprintf("methods expects a class or an instance but got %v", Repr(args[0])))
+		return nil
+	}))
+	i.env.Define("hasField", NewInterpreterPrimitive(2, func(i *Interpreter, args []any) any {
+		// private fields are never visible by name
+		if name, ok := args[1].(string); ok && strings.HasPrefix(name, "#") {
+			return false
+		}
+		name := fieldNameArg("hasField", args[1], i.line)
+		_, ok := instanceArg("hasField", args[0], name.line).Field(name.lexeme)
+		return ok
+	}))
+	i.env.Define("getField", NewInterpreterPrimitive(2, func(i *Interpreter, args []any) any {
+		name := fieldNameArg("getField", args[1], i.line)
+		val, ok := instanceArg("getField", args[0], name.line).Field(name.lexeme)
+		if !ok {
+			Panic(name.line, fmt.Sprintf("Undefined field '%s'.", name.lexeme))
+		}
+		return val
+	}))
+	i.env.Define("setField", NewInterpreterPrimitive(3, func(i *Interpreter, args []any) any {
+		name := fieldNameArg("setField", args[1], i.line)
+		instanceArg("setField", args[0], name.line).Set(name, args[2])
+		return args[2]
+	}))
+}
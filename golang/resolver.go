@@ -17,19 +17,32 @@ const (
 	CLASSNONE ClassType = iota
 	CLASSCLASS
 	CLASSSUB
+	CLASSTRAIT
 )
 
 type Resolver struct {
-	inter        *Interpreter
-	scopes       []map[string]bool
+	inter  *Interpreter
+	scopes []map[string]bool
+	// the slots of the names in the environments, one map per scope
+	slots []map[string]int
+	// names declared with const, one map per scope
+	constants    []map[string]bool
 	currnetFunc  FunctionType
 	currentClass ClassType
+	// whether the function being resolved is a generator
+	inGenerator bool
+	// names bound by the pattern being resolved
+	patternNames map[string]bool
+	// names already bound by the previous alternatives of a match arm
+	armNames map[string]bool
 }
 
 func NewResolver(inter *Interpreter) *Resolver {
 	return &Resolver{
 		inter:        inter,
 		scopes:       []map[string]bool{},
+		slots:        []map[string]int{},
+		constants:    []map[string]bool{},
 		currnetFunc:  NONE,
 		currentClass: CLASSNONE,
 	}
@@ -56,6 +69,19 @@ func (r *Resolver) VisitVarStmt(stmt Stmt) any {
 		r.resolveExpr(s.initializer)
 	}
 	r.define(s.name)
+	if s.constant && len(r.constants) != 0 {
+		r.constants[len(r.constants)-1][s.name.lexeme] = true
+	}
+	return nil
+}
+
+func (r *Resolver) VisitDestructureStmt(stmt Stmt) any {
+	s, ok := stmt.(*Destructure)
+	if !ok {
+		panic("should be destructure type stmt")
+	}
+	r.resolveExpr(s.initializer)
+	r.resolveBindings(s.pattern, s.constant)
 	return nil
 }
 
@@ -66,6 +92,10 @@ func (r *Resolver) VisitAssignExpr(expr Expr) any {
 	}
 	r.resolveExpr(e.value)
 	r.resolveLocal(e, e.name)
+	// globals are only known at runtime, Environment.Assign checks them
+	if r.isConstant(e.name) {
+		Panic(e.name.line, fmt.Sprintf("Can't assign to constant '%s'.", e.name.lexeme))
+	}
 	return nil
 }
 
@@ -86,9 +116,24 @@ func (r *Resolver) VisitSetExpr(expr Expr) any {
 	}
 	r.resolveExpr(e.object)
 	r.resolveExpr(e.value)
+	r.checkPrivateAccess(e.object, e.name)
 	return nil
 }
 
+// checkPrivateAccess rejects the access to a '#name' member through anything
+// but 'this' in a class, the class declaring it is checked at runtime.
+func (r *Resolver) checkPrivateAccess(object Expr, name *Token) {
+	if name.typ != PRIVATE_IDENTIFIER {
+		return
+	}
+	if r.currentClass == CLASSTRAIT {
+		Panic(name.line, fmt.Sprintf("Can't access private member '%s' in a trait.", name.lexeme))
+	}
+	if _, ok := object.(*This); !ok || r.currentClass == CLASSNONE {
+		Panic(name.line, fmt.Sprintf("Private member '%s' can only be accessed through 'this' inside its class.", name.lexeme))
+	}
+}
+
 func (r *Resolver) VisitSuperExpr(expr Expr) any {
 	e, ok := expr.(*Super)
 	if !ok {
@@ -97,6 +142,10 @@ func (r *Resolver) VisitSuperExpr(expr Expr) any {
 	if r.currentClass == CLASSNONE {
 		Panic(e.keyword.line, "Can't use 'super' outside of a class.")
 	}
+	// a trait doesn't know the classes it ends up in
+	if r.currentClass == CLASSTRAIT {
+		Panic(e.keyword.line, "Can't use 'super' in a trait.")
+	}
 	if r.currentClass != CLASSSUB {
 		Panic(e.keyword.line, "Can't use 'super' in a class with no superclass.")
 	}
@@ -110,6 +159,16 @@ func (r *Resolver) VisitGetExpr(expr Expr) any {
 		panic("should be get type expr")
 	}
 	r.resolveExpr(e.object)
+	r.checkPrivateAccess(e.object, e.name)
+	return nil
+}
+
+func (r *Resolver) VisitChainExpr(expr Expr) any {
+	e, ok := expr.(*Chain)
+	if !ok {
+		panic("should be chain type expr")
+	}
+	r.resolveExpr(e.expression)
 	return nil
 }
 
@@ -125,6 +184,133 @@ func (r *Resolver) VisitCallExpr(expr Expr) any {
 	return nil
 }
 
+func (r *Resolver) VisitSpawnExpr(expr Expr) any {
+	e, ok := expr.(*Spawn)
+	if !ok {
+		panic("should be spawn type expr")
+	}
+	r.resolveExpr(e.call)
+	return nil
+}
+
+func (r *Resolver) VisitMatchExpr(expr Expr) any {
+	e, ok := expr.(*Match)
+	if !ok {
+		panic("should be match type expr")
+	}
+	r.resolveExpr(e.subject)
+	for _, arm := range e.arms {
+		// every arm gets its own scope holding the names its patterns bind,
+		// alternatives may bind the same names.
+		r.beginScope()
+		r.armNames = make(map[string]bool)
+		for _, pattern := range arm.patterns {
+			r.patternNames = make(map[string]bool)
+			r.resolvePattern(pattern)
+		}
+		r.patternNames = nil
+		r.armNames = nil
+		if arm.body != nil {
+			r.resolveStmt(arm.body)
+		} else {
+			r.resolveExpr(arm.value)
+		}
+		r.endScope()
+	}
+	return nil
+}
+
+func (r *Resolver) VisitValuePattern(pattern Pattern) any {
+	p, ok := pattern.(*Value)
+	if !ok {
+		panic("should be value type pattern")
+	}
+	r.resolveExpr(p.value)
+	return nil
+}
+
+func (r *Resolver) VisitBindPattern(pattern Pattern) any {
+	p, ok := pattern.(*Bind)
+	if !ok {
+		panic("should be bind type pattern")
+	}
+	r.bindPatternName(p.name)
+	return nil
+}
+
+func (r *Resolver) VisitSequencePattern(pattern Pattern) any {
+	p, ok := pattern.(*Sequence)
+	if !ok {
+		panic("should be sequence type pattern")
+	}
+	for _, el := range p.elements {
+		r.resolvePattern(el)
+	}
+	if p.rest != nil {
+		r.bindPatternName(p.rest)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitFieldsPattern(pattern Pattern) any {
+	p, ok := pattern.(*Fields)
+	if !ok {
+		panic("should be fields type pattern")
+	}
+	for _, sub := range p.patterns {
+		r.resolvePattern(sub)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitInstancePattern(pattern Pattern) any {
+	p, ok := pattern.(*Instance)
+	if !ok {
+		panic("should be instance type pattern")
+	}
+	r.resolveExpr(p.class)
+	for _, sub := range p.patterns {
+		r.resolvePattern(sub)
+	}
+	return nil
+}
+
+// bindPatternName declares a name bound by a pattern in the current scope,
+// '_' binds nothing.
+func (r *Resolver) bindPatternName(name *Token) {
+	if name.lexeme == "_" {
+		return
+	}
+	if r.patternNames[name.lexeme] {
+		Panic(name.line, fmt.Sprintf("Duplicate binding '%s' in pattern.", name.lexeme))
+	}
+	r.patternNames[name.lexeme] = true
+	if r.armNames[name.lexeme] {
+		return
+	}
+	r.declare(name)
+	r.define(name)
+	if r.armNames != nil {
+		r.armNames[name.lexeme] = true
+	}
+}
+
+// resolveBindings declares the names bound by a destructuring pattern.
+func (r *Resolver) resolveBindings(pattern Pattern, constant bool) {
+	r.patternNames = make(map[string]bool)
+	r.resolvePattern(pattern)
+	if constant && len(r.constants) != 0 {
+		for name := range r.patternNames {
+			r.constants[len(r.constants)-1][name] = true
+		}
+	}
+	r.patternNames = nil
+}
+
+func (r *Resolver) resolvePattern(pattern Pattern) {
+	pattern.Accept(r)
+}
+
 func (r *Resolver) VisitGroupingExpr(expr Expr) any {
 	e, ok := expr.(*Grouping)
 	if !ok {
@@ -225,6 +411,26 @@ func (r *Resolver) VisitReturnStmt(stmt Stmt) any {
 		if r.currnetFunc == INITIALIZER {
 			Panic(s.keyword.line, "Can't return from initializer.")
 		}
+		if r.inGenerator {
+			Panic(s.keyword.line, "Can't return a value from a generator.")
+		}
+		r.resolveExpr(s.value)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitYieldStmt(stmt Stmt) any {
+	s, ok := stmt.(*Yield)
+	if !ok {
+		panic("should be yield type stmt")
+	}
+	if r.currnetFunc == NONE {
+		Panic(s.keyword.line, "Can't yield from top-level code.")
+	}
+	if r.currnetFunc == INITIALIZER {
+		Panic(s.keyword.line, "Can't yield from initializer.")
+	}
+	if s.value != nil {
 		r.resolveExpr(s.value)
 	}
 	return nil
@@ -242,16 +448,33 @@ func (r *Resolver) VisitWhileStmt(stmt Stmt) any {
 
 func (r *Resolver) resolveFunction(fn *Function, typ FunctionType) {
 	enclosingFunction := r.currnetFunc
+	enclosingGenerator := r.inGenerator
 	r.currnetFunc = typ
+	r.inGenerator = fn.generator
 	defer func() {
 		r.currnetFunc = enclosingFunction
+		r.inGenerator = enclosingGenerator
 	}()
 
 	r.beginScope()
-	for _, param := range fn.params {
+	for k, param := range fn.params {
+		// defaults are evaluated in the call environment so they can refer
+		// to the parameters before them
+		if fn.defaults[k] != nil {
+			r.resolveExpr(fn.defaults[k])
+		}
 		r.declare(param)
 		r.define(param)
 	}
+	if fn.rest != nil {
+		r.declare(fn.rest)
+		r.define(fn.rest)
+	}
+	for _, pattern := range fn.patterns {
+		if pattern != nil {
+			r.resolveBindings(pattern, false)
+		}
+	}
 	r.resolveStmts(fn.body)
 	r.endScope()
 }
@@ -267,12 +490,23 @@ func (r *Resolver) define(token *Token) {
 func (r *Resolver) resolveLocal(expr Expr, name *Token) {
 	for i := len(r.scopes) - 1; i >= 0; i-- {
 		if r.scopes[i][name.lexeme] {
-			r.inter.resolve(expr, len(r.scopes)-1-i)
+			r.inter.resolve(expr, len(r.scopes)-1-i, r.slots[i][name.lexeme])
 			return
 		}
 	}
 }
 
+// isConstant reports whether the local variable the name refers to was
+// declared with const.
+func (r *Resolver) isConstant(name *Token) bool {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if _, ok := r.scopes[i][name.lexeme]; ok {
+			return r.constants[i][name.lexeme]
+		}
+	}
+	return false
+}
+
 func (r *Resolver) declare(token *Token) {
 	if len(r.scopes) == 0 {
 		return
@@ -282,6 +516,8 @@ func (r *Resolver) declare(token *Token) {
 		Panic(token.line, "Already a variable with this name in this scope.")
 	}
 	scope[token.lexeme] = false
+	slots := r.slots[len(r.slots)-1]
+	slots[token.lexeme] = len(slots)
 }
 
 func (r *Resolver) resolveExpr(expr Expr) {
@@ -316,6 +552,26 @@ func (r *Resolver) Resolve(stmts []Stmt) {
 	r.resolveStmts(stmts)
 }
 
+func (r *Resolver) VisitEnumStmt(stmt Stmt) any {
+	s, ok := stmt.(*Enum)
+	if !ok {
+		panic("should be enum type stmt")
+	}
+	r.declare(s.name)
+	r.define(s.name)
+	seen := make(map[string]bool)
+	for _, member := range s.members {
+		if member.lexeme == "values" {
+			Panic(member.line, "Enum member can't be named 'values'.")
+		}
+		if seen[member.lexeme] {
+			Panic(member.line, fmt.Sprintf("Duplicate enum member '%s'.", member.lexeme))
+		}
+		seen[member.lexeme] = true
+	}
+	return nil
+}
+
 func (r *Resolver) VisitClassStmt(stmt Stmt) any {
 	s, ok := stmt.(*Class)
 	if !ok {
@@ -335,13 +591,17 @@ func (r *Resolver) VisitClassStmt(stmt Stmt) any {
 		r.resolveExpr(s.superclass)
 	}
 
+	r.resolveTraits(s.name, s.traits)
+
 	if s.superclass != nil {
 		r.beginScope()
 		r.scopes[len(r.scopes)-1]["super"] = true
+		r.slots[len(r.slots)-1]["super"] = 0
 	}
 
 	r.beginScope()
 	r.scopes[len(r.scopes)-1]["this"] = true
+	r.slots[len(r.slots)-1]["this"] = 0
 
 	for _, method := range s.methods {
 		functionType := METHOD
@@ -363,6 +623,46 @@ func (r *Resolver) VisitClassStmt(stmt Stmt) any {
 	return nil
 }
 
+func (r *Resolver) VisitTraitStmt(stmt Stmt) any {
+	s, ok := stmt.(*Trait)
+	if !ok {
+		panic("should be trait type stmt")
+	}
+	enclosingClass := r.currentClass
+	r.currentClass = CLASSTRAIT
+
+	r.declare(s.name)
+	r.resolveTraits(s.name, s.traits)
+
+	r.beginScope()
+	r.scopes[len(r.scopes)-1]["this"] = true
+	r.slots[len(r.slots)-1]["this"] = 0
+	for _, method := range s.methods {
+		if method.name.lexeme == "init" {
+			Panic(method.name.line, "A trait can't have an initializer.")
+		}
+		if method.name.typ == PRIVATE_IDENTIFIER {
+			Panic(method.name.line, "A trait can't have private methods.")
+		}
+		r.resolveFunction(method, METHOD)
+	}
+	r.endScope()
+
+	r.define(s.name)
+
+	r.currentClass = enclosingClass
+	return nil
+}
+
+func (r *Resolver) resolveTraits(name *Token, traits []*Variable) {
+	for _, trait := range traits {
+		if trait.name.lexeme == name.lexeme {
+			Panic(trait.name.line, fmt.Sprintf("'%s' can't include itself.", name.lexeme))
+		}
+		r.resolveExpr(trait)
+	}
+}
+
 func (r *Resolver) resolveStmts(stmts []Stmt) {
 	for _, stmt := range stmts {
 		stmt.Accept(r)
@@ -375,10 +675,14 @@ func (r *Resolver) resolveStmt(stmt Stmt) {
 
 func (r *Resolver) beginScope() {
 	r.scopes = append(r.scopes, map[string]bool{})
+	r.slots = append(r.slots, map[string]int{})
+	r.constants = append(r.constants, map[string]bool{})
 }
 func (r *Resolver) endScope() {
 	if len(r.scopes) == 0 {
 		panic("unreachable")
 	}
 	r.scopes = r.scopes[:len(r.scopes)-1]
+	r.slots = r.slots[:len(r.slots)-1]
+	r.constants = r.constants[:len(r.constants)-1]
 }
@@ -2,70 +2,99 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 var keywords = map[string]TokenType{
-	"and":    AND,
-	"class":  CLASS,
-	"else":   ELSE,
-	"false":  FALSE,
-	"for":    FOR,
-	"fun":    FUN,
-	"if":     IF,
-	"nil":    NIL,
-	"or":     OR,
-	"print":  PRINT,
-	"return": RETURN,
-	"super":  SUPER,
-	"this":   THIS,
-	"true":   TRUE,
-	"var":    VAR,
-	"while":  WHILE,
+	"and":        AND,
+	"case":       CASE,
+	"class":      CLASS,
+	"const":      CONST,
+	"default":    DEFAULT,
+	"else":       ELSE,
+	"enum":       ENUM,
+	"false":      FALSE,
+	"for":        FOR,
+	"fun":        FUN,
+	"if":         IF,
+	"instanceof": INSTANCEOF,
+	"match":      MATCH,
+	"nil":        NIL,
+	"or":         OR,
+	"print":      PRINT,
+	"return":     RETURN,
+	"spawn":      SPAWN,
+	"super":      SUPER,
+	"this":       THIS,
+	"trait":      TRAIT,
+	"true":       TRUE,
+	"var":        VAR,
+	"while":      WHILE,
+	"with":       WITH,
+	"yield":      YIELD,
 }
 
 type TokenType int
 
 var tokens = map[TokenType]string{
-	LEFT_PAREN:    "LEFT_PAREN",
-	RIGHT_PAREN:   "RIGHT_PAREN",
-	LEFT_BRACE:    "LEFT_BRACE",
-	RIGHT_BRACE:   "RIGHT_BRACE",
-	COMMA:         "COMMA",
-	DOT:           "DOT",
-	MINUS:         "MINUS",
-	PLUS:          "PLUS",
-	SEMICOLON:     "SEMICOLON",
-	SLASH:         "SLASH",
-	STAR:          "STAR",
-	BANG:          "BANG",
-	BANG_EQUAL:    "BANG_EQUAL",
-	EQUAL:         "EQUAL",
-	EQUAL_EQUAL:   "EQUAL_EQUAL",
-	GREATER:       "GREATER",
-	GREATER_EQUAL: "GREATER_EQUAL",
-	LESS:          "LESS",
-	LESS_EQUAL:    "LESS_EQUAL",
-	IDENTIFIER:    "IDENTIFIER",
-	STRING:        "STRING",
-	NUMBER:        "NUMBER",
-	AND:           "AND",
-	CLASS:         "CLASS",
-	ELSE:          "ELSE",
-	FALSE:         "FALSE",
-	FUN:           "FUN",
-	FOR:           "FOR",
-	IF:            "IF",
-	NIL:           "NIL",
-	OR:            "OR",
-	PRINT:         "PRINT",
-	RETURN:        "RETURN",
-	SUPER:         "SUPER",
-	THIS:          "THIS",
-	TRUE:          "TRUE",
-	VAR:           "VAR",
-	WHILE:         "WHILE",
-	EOF:           "EOF",
+	LEFT_PAREN:         "LEFT_PAREN",
+	RIGHT_PAREN:        "RIGHT_PAREN",
+	LEFT_BRACE:         "LEFT_BRACE",
+	RIGHT_BRACE:        "RIGHT_BRACE",
+	LEFT_BRACKET:       "LEFT_BRACKET",
+	RIGHT_BRACKET:      "RIGHT_BRACKET",
+	COMMA:              "COMMA",
+	COLON:              "COLON",
+	DOT:                "DOT",
+	ELLIPSIS:           "ELLIPSIS",
+	MINUS:              "MINUS",
+	PLUS:               "PLUS",
+	SEMICOLON:          "SEMICOLON",
+	SLASH:              "SLASH",
+	STAR:               "STAR",
+	BANG:               "BANG",
+	BANG_EQUAL:         "BANG_EQUAL",
+	EQUAL:              "EQUAL",
+	EQUAL_EQUAL:        "EQUAL_EQUAL",
+	ARROW:              "ARROW",
+	GREATER:            "GREATER",
+	GREATER_EQUAL:      "GREATER_EQUAL",
+	LESS:               "LESS",
+	LESS_EQUAL:         "LESS_EQUAL",
+	QUESTION_DOT:       "QUESTION_DOT",
+	QUESTION_QUESTION:  "QUESTION_QUESTION",
+	IDENTIFIER:         "IDENTIFIER",
+	PRIVATE_IDENTIFIER: "PRIVATE_IDENTIFIER",
+	STRING:             "STRING",
+	NUMBER:             "NUMBER",
+	AND:                "AND",
+	CASE:               "CASE",
+	CLASS:              "CLASS",
+	CONST:              "CONST",
+	DEFAULT:            "DEFAULT",
+	ELSE:               "ELSE",
+	ENUM:               "ENUM",
+	FALSE:              "FALSE",
+	FUN:                "FUN",
+	FOR:                "FOR",
+	IF:                 "IF",
+	MATCH:              "MATCH",
+	NIL:                "NIL",
+	OR:                 "OR",
+	PRINT:              "PRINT",
+	RETURN:             "RETURN",
+	SPAWN:              "SPAWN",
+	SUPER:              "SUPER",
+	THIS:               "THIS",
+	TRAIT:              "TRAIT",
+	TRUE:               "TRUE",
+	VAR:                "VAR",
+	WHILE:              "WHILE",
+	WITH:               "WITH",
+	YIELD:              "YIELD",
+	EOF:                "EOF",
 }
 
 const (
@@ -74,7 +103,10 @@ const (
 	RIGHT_PAREN
 	LEFT_BRACE
 	RIGHT_BRACE
+	LEFT_BRACKET
+	RIGHT_BRACKET
 	COMMA
+	COLON
 	DOT
 	MINUS
 	PLUS
@@ -82,38 +114,53 @@ const (
 	SLASH
 	STAR
 
-	// One or two character tokens.
+	// One, two or three character tokens.
 	BANG
 	BANG_EQUAL
 	EQUAL
 	EQUAL_EQUAL
+	ARROW
 	GREATER
 	GREATER_EQUAL
 	LESS
 	LESS_EQUAL
+	QUESTION_DOT
+	QUESTION_QUESTION
+	ELLIPSIS
 
 	// Literals.
 	IDENTIFIER
+	PRIVATE_IDENTIFIER
 	STRING
 	NUMBER
 
 	// Keywords.
 	AND
+	CASE
 	CLASS
+	CONST
+	DEFAULT
 	ELSE
+	ENUM
 	FALSE
 	FUN
 	FOR
 	IF
+	INSTANCEOF
+	MATCH
 	NIL
 	OR
 	PRINT
 	RETURN
+	SPAWN
 	SUPER
 	THIS
+	TRAIT
 	TRUE
 	VAR
 	WHILE
+	WITH
+	YIELD
 
 	EOF
 )
@@ -177,10 +224,22 @@ func (s *Scanner) scanToken() {
 		s.addToken1(LEFT_BRACE)
 	case '}':
 		s.addToken1(RIGHT_BRACE)
+	case '[':
+		s.addToken1(LEFT_BRACKET)
+	case ']':
+		s.addToken1(RIGHT_BRACKET)
 	case ',':
 		s.addToken1(COMMA)
+	case ':':
+		s.addToken1(COLON)
 	case '.':
-		s.addToken1(DOT)
+		if s.peek() == '.' && s.peekNext() == '.' {
+			s.advance()
+			s.advance()
+			s.addToken1(ELLIPSIS)
+		} else {
+			s.addToken1(DOT)
+		}
 	case '-':
 		s.addToken1(MINUS)
 	case '+':
@@ -198,6 +257,8 @@ func (s *Scanner) scanToken() {
 	case '=':
 		if s.match('=') {
 			s.addToken1(EQUAL_EQUAL)
+		} else if s.match('>') {
+			s.addToken1(ARROW)
 		} else {
 			s.addToken1(EQUAL)
 		}
@@ -213,6 +274,14 @@ func (s *Scanner) scanToken() {
 		} else {
 			s.addToken1(GREATER)
 		}
+	case '?':
+		if s.match('.') {
+			s.addToken1(QUESTION_DOT)
+		} else if s.match('?') {
+			s.addToken1(QUESTION_QUESTION)
+		} else {
+			Error(s.line, "Unexpected character.")
+		}
 	case '/':
 		if s.match('/') {
 			for s.peek() != '\n' && !s.isAtEnd() {
@@ -230,9 +299,11 @@ func (s *Scanner) scanToken() {
 		s.line++
 	case '"':
 		s.string()
-	case 'o':
-		if s.match('r') {
-			s.addToken1(OR)
+	case '#':
+		if s.isAlpha(s.peek()) {
+			s.privateIdentifier()
+		} else {
+			Error(s.line, "Unexpected character.")
 		}
 	default:
 		if s.isDigit(c) {
@@ -267,6 +338,15 @@ func (s *Scanner) identifier() {
 	s.addToken1(IDENTIFIER)
 }
 
+// privateIdentifier scans a '#name' member name, only visible inside the
+// class declaring it.
+func (s *Scanner) privateIdentifier() {
+	for s.isAlphaNumber(s.peek()) {
+		s.advance()
+	}
+	s.addToken1(PRIVATE_IDENTIFIER)
+}
+
 func (s *Scanner) number() {
 	for s.isDigit(s.peek()) {
 		s.advance()
@@ -277,7 +357,22 @@ func (s *Scanner) number() {
 	for s.isDigit(s.peek()) {
 		s.advance()
 	}
-	num, err := strconv.ParseFloat(s.source[s.start:s.current], 64)
+	text := s.source[s.start:s.current]
+	// '123n' is a big integer literal
+	if s.peek() == 'n' && !strings.Contains(text, ".") {
+		s.advance()
+		value, _ := new(big.Int).SetString(text, 10)
+		s.addToken(NUMBER, value)
+		return
+	}
+	// integer literals a float64 can't hold exactly are promoted
+	if !strings.Contains(text, ".") {
+		if value, _ := new(big.Int).SetString(text, 10); value.Cmp(big.NewInt(maxSafeInteger)) > 0 {
+			s.addToken(NUMBER, value)
+			return
+		}
+	}
+	num, err := strconv.ParseFloat(text, 64)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tokenTypes(source string) []TokenType {
+	var types []TokenType
+	for _, token := range NewScanner(source).ScanTokens() {
+		types = append(types, token.typ)
+	}
+	return types
+}
+
+func TestScanIdentifierStartingWithO(t *testing.T) {
+	assert.Equal(t, []TokenType{IDENTIFIER, OR, IDENTIFIER, EOF}, tokenTypes("other or orbit"))
+	tokens := NewScanner("other").ScanTokens()
+	assert.Equal(t, "other", tokens[0].lexeme)
+}
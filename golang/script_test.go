@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// update rewrites the golden outputs: go test -run TestScripts -update
+var update = flag.Bool("update", false, "rewrite the .out files of testdata")
+
+// vmMarker is the first line of the scripts the VM runs too, their output
+// must be the same with both backends.
+const vmMarker = "// backends: tree, vm"
+
+// captureOutput returns what fn prints.
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	fn()
+	w.Close()
+	os.Stdout = stdout
+	return <-out
+}
+
+// runScript runs source the way glox runs a file, in a fresh interpreter,
+// and returns what it printed.
+func runScript(t *testing.T, source string, useVM bool) string {
+	t.Helper()
+	inter = NewInterpreter()
+	defer inter.Close()
+	vm = nil
+	if useVM {
+		vm = NewVM(inter)
+	}
+	hadError = false
+	defer func() {
+		vm = nil
+		hadError = false
+	}()
+	return captureOutput(t, func() {
+		run(source, false)
+	})
+}
+
+// TestScripts runs the scripts of testdata and compares what they print
+// with the .out file next to them.
+func TestScripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.lox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".lox"), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := runScript(t, string(source), false)
+			golden := strings.TrimSuffix(file, ".lox") + ".out"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(want), got)
+			if strings.HasPrefix(string(source), vmMarker) {
+				assert.Equal(t, got, runScript(t, string(source), true), "the VM printed something else")
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Capabilities are the groups of builtins touching the host a script may
+// call, the builtins of the other groups raise a runtime error.
+type Capabilities struct {
+	// readFile, writeFile, fileExists and listDir
+	FS bool
+	// FSRoot confines the paths of the fs builtins to a directory when set,
+	// they are relative to it and can't leave it
+	FSRoot string
+	// getenv
+	Env bool
+	// clock and sleep
+	Time bool
+	// args and exit
+	Process bool
+}
+
+// AllCapabilities grants every group, it's what glox starts with.
+func AllCapabilities() Capabilities {
+	return Capabilities{FS: true, Env: true, Time: true, Process: true}
+}
+
+// DefaultCapabilities is what NewInterpreter starts with, it leaves out the
+// groups reaching the files, the environment and the process of the host.
+func DefaultCapabilities() Capabilities {
+	return Capabilities{Time: true}
+}
+
+// SetCapabilities replaces the groups of builtins the scripts may call.
+func (i *Interpreter) SetCapabilities(caps Capabilities) {
+	i.capabilities = caps
+}
+
+// require raises the runtime error of fn when group isn't granted.
+func (i *Interpreter) require(fn, group string, granted bool) {
+	if !granted {
+		Panic(i.line, fmt.Sprintf("%s: capability '%s' not granted", fn, group))
+	}
+}
+
+// hostPath returns the host path of a path given to an fs builtin.
+func (i *Interpreter) hostPath(fn string, v any) string {
+	path, ok := v.(string)
+	if !ok {
+		Panic(i.line, fmt.Sprintf("%s expects a path but got %v", fn, Repr(v)))
+	}
+	root := i.capabilities.FSRoot
+	if root == "" {
+		return path
+	}
+	// cleaning it as an absolute path drops the .. leading out of the root
+	full := filepath.Join(root, filepath.Clean("/"+path))
+	if !within(root, full) {
+		Panic(i.line, fmt.Sprintf("%s: path %v is outside of the fs root", fn, Repr(path)))
+	}
+	return full
+}
+
+// within reports whether path stays in root once the symbolic links of the
+// part of it which exists are followed.
+func within(root, path string) bool {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	existing := path
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			rel, err := filepath.Rel(realRoot, real)
+			return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return false
+		}
+		existing = parent
+	}
+}
+
+// fsError describes the failure of an fs builtin without the host path.
+func fsError(fn string, path any, err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return fmt.Sprintf("%s failed on %v: %v", fn, Repr(path), err)
+}
+
+func injectStdlib(i *Interpreter) {
+	i.env.Define("readFile", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		i.require("readFile", "fs", i.capabilities.FS)
+		content, err := os.ReadFile(i.hostPath("readFile", args[0]))
+		if err != nil {
+			Panic(i.line, fsError("readFile", args[0], err))
+		}
+		i.allocate(stringSize + len(content))
+		return string(content)
+	}))
+	i.env.Define("writeFile", NewInterpreterPrimitive(2, func(i *Interpreter, args []any) any {
+		i.require("writeFile", "fs", i.capabilities.FS)
+		content, ok := args[1].(string)
+		if !ok {
+			Panic(i.line, fmt.Sprintf("writeFile expects a string but got %v", Repr(args[1])))
+		}
+		if err := os.WriteFile(i.hostPath("writeFile", args[0]), []byte(content), 0o644); err != nil {
+			Panic(i.line, fsError("writeFile", args[0], err))
+		}
+		return nil
+	}))
+	i.env.Define("fileExists", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		i.require("fileExists", "fs", i.capabilities.FS)
+		_, err := os.Stat(i.hostPath("fileExists", args[0]))
+		return err == nil
+	}))
+	i.env.Define("listDir", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		i.require("listDir", "fs", i.capabilities.FS)
+		entries, err := os.ReadDir(i.hostPath("listDir", args[0]))
+		if err != nil {
+			Panic(i.line, fsError("listDir", args[0], err))
+		}
+		names := make([]string, len(entries))
+		for k, entry := range entries {
+			names[k] = entry.Name()
+		}
+		return namesList(names)
+	}))
+	i.env.Define("getenv", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		i.require("getenv", "env", i.capabilities.Env)
+		name, ok := args[0].(string)
+		if !ok {
+			Panic(i.line, fmt.Sprintf("getenv expects a string but got %v", Repr(args[0])))
+		}
+		if val, ok := os.LookupEnv(name); ok {
+			return val
+		}
+		return nil
+	}))
+	i.env.Define("clock", NewInterpreterPrimitive(0, func(i *Interpreter, args []any) any {
+		i.require("clock", "time", i.capabilities.Time)
+		return float64(time.Now().Unix())
+	}))
+	i.env.Define("sleep", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		i.require("sleep", "time", i.capabilities.Time)
+		seconds, ok := args[0].(float64)
+		if !ok || seconds < 0 {
+			Panic(i.line, fmt.Sprintf("sleep expects a non-negative number but got %v", Repr(args[0])))
+		}
+		timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-i.done():
+			i.abort()
+		}
+		return nil
+	}))
+	i.env.Define("args", NewInterpreterPrimitive(0, func(i *Interpreter, args []any) any {
+		i.require("args", "process", i.capabilities.Process)
+		return namesList(os.Args[1:])
+	}))
+	i.env.Define("exit", NewInterpreterPrimitive(1, func(i *Interpreter, args []any) any {
+		i.require("exit", "process", i.capabilities.Process)
+		code, ok := args[0].(float64)
+		if !ok || code != float64(int(code)) {
+			Panic(i.line, fmt.Sprintf("exit expects an integer but got %v", Repr(args[0])))
+		}
+		// the host exits, once the run unwound
+		panic(&ExitError{Code: int(code)})
+	}))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// executeWith runs source in an interpreter granted caps.
+func executeWith(t *testing.T, caps Capabilities, source string, useVM bool) (any, error) {
+	t.Helper()
+	inter := NewInterpreter()
+	t.Cleanup(inter.Close)
+	inter.SetCapabilities(caps)
+	return executeIn(t, context.Background(), inter, source, useVM, Options{})
+}
+
+func TestCapabilitiesNotGranted(t *testing.T) {
+	for source, msg := range map[string]string{
+		`readFile("a");`:       "readFile: capability 'fs' not granted",
+		`writeFile("a", "b");`: "writeFile: capability 'fs' not granted",
+		`fileExists("a");`:     "fileExists: capability 'fs' not granted",
+		`listDir(".");`:        "listDir: capability 'fs' not granted",
+		`getenv("HOME");`:      "getenv: capability 'env' not granted",
+		`clock();`:             "clock: capability 'time' not granted",
+		`sleep(0);`:            "sleep: capability 'time' not granted",
+		`args();`:              "args: capability 'process' not granted",
+		`exit(0);`:             "exit: capability 'process' not granted",
+	} {
+		for _, useVM := range []bool{false, true} {
+			_, err := executeWith(t, Capabilities{}, source, useVM)
+			assert.ErrorContains(t, err, msg, "vm: %v", useVM)
+		}
+	}
+	_, err := executeWith(t, Capabilities{Time: true}, "clock(); sleep(0);", false)
+	assert.NoError(t, err)
+}
+
+func TestFSRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("s"), 0o644))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(root, "link")))
+	caps := Capabilities{FS: true, FSRoot: root}
+
+	_, err := executeWith(t, caps, `writeFile("/notes", "hi"); if (readFile("notes") != "hi") nope;`, false)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(root, "notes"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(content))
+
+	// leading .. stay at the root
+	_, err = executeWith(t, caps, `if (readFile("../../notes") != "hi") nope;`, false)
+	assert.NoError(t, err)
+
+	_, err = executeWith(t, caps, `readFile("link/secret");`, false)
+	assert.ErrorContains(t, err, `readFile: path "link/secret" is outside of the fs root`)
+
+	// the host path isn't shown
+	_, err = executeWith(t, caps, `readFile("missing");`, false)
+	assert.ErrorContains(t, err, `readFile failed on "missing": no such file or directory`)
+	assert.NotContains(t, err.Error(), root)
+}
+
+func TestDefaultCapabilities(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		_, err := execute(t, `clock(); readFile("a");`, useVM, Options{})
+		assert.ErrorContains(t, err, "readFile: capability 'fs' not granted", "vm: %v", useVM)
+		_, err = execute(t, `getenv("HOME");`, useVM, Options{})
+		assert.ErrorContains(t, err, "getenv: capability 'env' not granted", "vm: %v", useVM)
+		_, err = execute(t, `exit(0);`, useVM, Options{})
+		assert.ErrorContains(t, err, "exit: capability 'process' not granted", "vm: %v", useVM)
+	}
+}
+
+// TestExitUnwinds checks exit ends the run with its code rather than the
+// process.
+func TestExitUnwinds(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		_, err := executeWith(t, Capabilities{Process: true}, "fun f() { exit(3); }\nf();\nnope;", useVM)
+		var exit *ExitError
+		if assert.ErrorAs(t, err, &exit, "vm: %v", useVM) {
+			assert.Equal(t, 3, exit.Code)
+		}
+	}
+}
+
+// TestBuiltinErrorsHaveLines checks the builtins report the line of their
+// call like the other runtime errors.
+func TestBuiltinErrorsHaveLines(t *testing.T) {
+	caps := AllCapabilities()
+	caps.FSRoot = t.TempDir()
+	for _, source := range []string{
+		`Channel(-1);`,
+		`var c = Channel(1); c.close(); c.close();`,
+		`var c = Channel(1); c.close(); c.send(1);`,
+		`List(1).get(3);`,
+		`List(1).set("a", 2);`,
+		`class A { init() { this.#x = 1; } } getField(A(), "#x");`,
+		`fields(1);`,
+		`methods(1);`,
+		`freeze(1);`,
+		`Decimal("x");`,
+		`fun f(a, b = 1) {} f(b: 2);`,
+		`readFile(1);`,
+		`readFile("../../../../../../etc/passwd/x");`,
+		`writeFile("a", 1);`,
+		`getenv(1);`,
+		`sleep(-1);`,
+		`exit(0.5);`,
+	} {
+		for _, useVM := range []bool{false, true} {
+			_, err := executeWith(t, caps, "\n"+source, useVM)
+			if assert.Error(t, err, source) {
+				assert.True(t, strings.HasPrefix(err.Error(), "[line 2] "), "vm: %v, %s: %v", useVM, source, err)
+			}
+		}
+	}
+}
@@ -12,10 +12,14 @@ type StmtVisitor interface {
 	VisitPrintStmt(Stmt) any
 	VisitReturnStmt(Stmt) any
 	VisitVarStmt(Stmt) any
+	VisitDestructureStmt(Stmt) any
 	VisitBlockStmt(Stmt) any
 	VisitClassStmt(Stmt) any
+	VisitTraitStmt(Stmt) any
 	VisitIfStmt(Stmt) any
 	VisitWhileStmt(Stmt) any
+	VisitYieldStmt(Stmt) any
+	VisitEnumStmt(Stmt) any
 }
 
 type Expression struct {
@@ -27,9 +31,16 @@ func (e *Expression) Accept(v StmtVisitor) (ret any) {
 }
 
 type Function struct {
-	name   *Token
-	params []*Token
-	body   []Stmt
+	name       *Token
+	params     []*Token
+	body       []Stmt
+	generator  bool
+	defaults   []Expr
+	rest       *Token
+	patterns   []Pattern
+	paramTypes []*Token
+	returnType *Token
+	restType   *Token
 }
 
 func (e *Function) Accept(v StmtVisitor) (ret any) {
@@ -56,12 +67,25 @@ func (e *Return) Accept(v StmtVisitor) (ret any) {
 type Var struct {
 	name        *Token
 	initializer Expr
+	constant    bool
+	annotation  *Token
 }
 
 func (e *Var) Accept(v StmtVisitor) (ret any) {
 	return v.VisitVarStmt(e)
 }
 
+type Destructure struct {
+	keyword     *Token
+	pattern     Pattern
+	initializer Expr
+	constant    bool
+}
+
+func (e *Destructure) Accept(v StmtVisitor) (ret any) {
+	return v.VisitDestructureStmt(e)
+}
+
 type Block struct {
 	statements []Stmt
 }
@@ -74,12 +98,25 @@ type Class struct {
 	name       *Token
 	superclass *Variable
 	methods    []*Function
+	traits     []*Variable
+	fields     []*Token
+	fieldTypes []*Token
 }
 
 func (e *Class) Accept(v StmtVisitor) (ret any) {
 	return v.VisitClassStmt(e)
 }
 
+type Trait struct {
+	name    *Token
+	traits  []*Variable
+	methods []*Function
+}
+
+func (e *Trait) Accept(v StmtVisitor) (ret any) {
+	return v.VisitTraitStmt(e)
+}
+
 type If struct {
 	condition  Expr
 	thenBranch Stmt
@@ -98,3 +135,21 @@ type While struct {
 func (e *While) Accept(v StmtVisitor) (ret any) {
 	return v.VisitWhileStmt(e)
 }
+
+type Yield struct {
+	keyword *Token
+	value   Expr
+}
+
+func (e *Yield) Accept(v StmtVisitor) (ret any) {
+	return v.VisitYieldStmt(e)
+}
+
+type Enum struct {
+	name    *Token
+	members []*Token
+}
+
+func (e *Enum) Accept(v StmtVisitor) (ret any) {
+	return v.VisitEnumStmt(e)
+}
@@ -18,28 +18,43 @@ func main() {
 	defineAst(outputDir, "Expr", []string{
 		"Binary:left Expr,operator *Token,right Expr",
 		"Grouping:expression Expr",
-		"Literal:value any",
+		"Literal:value any,line int",
 		"Unary:operator *Token,right Expr",
 		"Variable:name *Token",
 		"Assign:name *Token,value Expr",
 		"Logical:left Expr,operator *Token,right Expr",
-		"Call:callee Expr,paren *Token,arguments []Expr",
-		"Get:object Expr,name *Token",
+		"Call:callee Expr,paren *Token,arguments []Expr,names []*Token",
+		"Get:object Expr,name *Token,optional bool",
 		"Set:object Expr,name *Token,value Expr",
 		"Super:keyword *Token,method *Token",
 		"This:keyword *Token",
+		"Spawn:keyword *Token,call *Call",
+		"Match:keyword *Token,subject Expr,arms []*MatchArm",
+		"Chain:expression Expr",
 	})
 
 	defineAst(outputDir, "Stmt", []string{
 		"Expression:expr Expr",
-		"Function:name *Token,params []*Token,body []Stmt",
+		"Function:name *Token,params []*Token,body []Stmt,generator bool,defaults []Expr,rest *Token,patterns []Pattern,paramTypes []*Token,returnType *Token,restType *Token",
 		"Print:expr Expr",
 		"Return:keyword *Token,value Expr",
-		"Var:name *Token,initializer Expr",
+		"Var:name *Token,initializer Expr,constant bool,annotation *Token",
+		"Destructure:keyword *Token,pattern Pattern,initializer Expr,constant bool",
 		"Block:statements []Stmt",
-		"Class:name *Token,superclass *Variable,methods []*Function",
+		"Class:name *Token,superclass *Variable,methods []*Function,traits []*Variable,fields []*Token,fieldTypes []*Token",
+		"Trait:name *Token,traits []*Variable,methods []*Function",
 		"If:condition Expr,thenBranch Stmt,elseBranch Stmt",
 		"While:condition Expr,body Stmt",
+		"Yield:keyword *Token,value Expr",
+		"Enum:name *Token,members []*Token",
+	})
+
+	defineAst(outputDir, "Pattern", []string{
+		"Value:value Expr",
+		"Bind:name *Token",
+		"Sequence:bracket *Token,elements []Pattern,rest *Token",
+		"Instance:class *Variable,paren *Token,fields []*Token,patterns []Pattern",
+		"Fields:brace *Token,names []*Token,patterns []Pattern",
 	})
 }
 
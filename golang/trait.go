@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LoxTrait is a named set of methods classes and other traits include with
+// 'with'. The methods of the included traits are flattened into it.
+type LoxTrait struct {
+	name    string
+	methods map[string]Callable
+	traits  []*LoxTrait
+}
+
+func NewLoxTrait(name string, traits []*LoxTrait, methods map[string]Callable) *LoxTrait {
+	return &LoxTrait{
+		name:    name,
+		methods: methods,
+		traits:  traits,
+	}
+}
+
+// Includes reports whether lt is other or includes it.
+func (lt *LoxTrait) Includes(other *LoxTrait) bool {
+	if lt == other {
+		return true
+	}
+	for _, t := range lt.traits {
+		if t.Includes(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func (lt *LoxTrait) ToString() string {
+	return lt.name
+}
+
+func (lt *LoxTrait) traitName() string {
+	return lt.name
+}
+
+func (lt *LoxTrait) traitMethods() map[string]Callable {
+	return lt.methods
+}
+
+// methodSet is a trait of either backend, M is the type of its methods.
+type methodSet[M any] interface {
+	traitName() string
+	traitMethods() map[string]M
+}
+
+// evaluateTraits evaluates the names after 'with'.
+func (i *Interpreter) evaluateTraits(names []*Variable) []*LoxTrait {
+	var traits []*LoxTrait
+	for _, name := range names {
+		trait, ok := i.evaluate(name).(*LoxTrait)
+		if !ok {
+			Panic(name.name.line, fmt.Sprintf("'%s' is not a trait.", name.name.lexeme))
+		}
+		traits = append(traits, trait)
+	}
+	return traits
+}
+
+// mergeTraits collects the methods of the traits included by the class or
+// trait declared at name. Two traits providing different methods with the
+// same name is a conflict, unless the declaration overrides the method.
+func mergeTraits[T methodSet[M], M any](name *Token, traits []T, own []*Function) map[string]M {
+	overridden := make(map[string]bool)
+	for _, method := range own {
+		overridden[method.name.lexeme] = true
+	}
+	methods := make(map[string]M)
+	providers := make(map[string]T)
+	for _, trait := range traits {
+		names := make([]string, 0, len(trait.traitMethods()))
+		for n := range trait.traitMethods() {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			method := trait.traitMethods()[n]
+			// the same trait reached through two paths isn't a conflict
+			if prev, ok := providers[n]; ok && any(methods[n]) != any(method) && !overridden[n] {
+				Panic(name.line, fmt.Sprintf("Method '%s' of '%s' conflicts between traits %s and %s.", n, name.lexeme, prev.traitName(), trait.traitName()))
+			}
+			methods[n] = method
+			providers[n] = trait
+		}
+	}
+	return methods
+}
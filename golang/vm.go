@@ -0,0 +1,1186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// stackInit is the initial size of the value stack, it grows as needed.
+const stackInit = 256
+
+// ObjFunction is a compiled function, the script itself is one with no name.
+type ObjFunction struct {
+	name string
+	// the number of parameters, the first required ones have no default
+	// value. With variadic the arguments after them go to the rest list.
+	arity    int
+	required int
+	variadic bool
+	// named by keyword arguments
+	params []*Token
+	// calling it returns a generator running its body
+	generator    bool
+	upvalueCount int
+	chunk        Chunk
+}
+
+func (f *ObjFunction) ToString() string {
+	if f.name == "" {
+		return "<script>"
+	}
+	return "<fn " + f.name + ">"
+}
+
+// arityMessage returns the error calling f with n arguments raises, if any.
+func (f *ObjFunction) arityMessage(n int) string {
+	max := f.arity
+	if f.variadic {
+		max = -1
+	}
+	return arityText(f.required, max, n)
+}
+
+// ObjUpvalue is a variable captured by a closure. It points into the stack
+// while the variable is alive there and holds the value once it's closed.
+type ObjUpvalue struct {
+	// the VM whose stack holds the variable, generators have their own
+	vm       *VM
+	location int
+	closed   any
+	isClosed bool
+	next     *ObjUpvalue
+}
+
+type ObjClosure struct {
+	function *ObjFunction
+	upvalues []*ObjUpvalue
+}
+
+func (c *ObjClosure) ToString() string {
+	return c.function.ToString()
+}
+
+type ObjClass struct {
+	name       string
+	superclass *ObjClass
+	methods    map[string]*ObjClosure
+	// the traits included with 'with', their methods are already merged
+	traits []*ObjTrait
+}
+
+func (c *ObjClass) ToString() string {
+	return c.name
+}
+
+func (c *ObjClass) IsSubclassOf(other *ObjClass) bool {
+	for k := c; k != nil; k = k.superclass {
+		if k == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Includes reports whether c or one of its superclasses includes the trait.
+func (c *ObjClass) Includes(trait *ObjTrait) bool {
+	for k := c; k != nil; k = k.superclass {
+		for _, t := range k.traits {
+			if t.Includes(trait) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ObjTrait is a trait declared in a compiled script.
+type ObjTrait struct {
+	name    string
+	methods map[string]*ObjClosure
+	traits  []*ObjTrait
+}
+
+func (t *ObjTrait) ToString() string {
+	return t.name
+}
+
+// Includes reports whether t is other or includes it.
+func (t *ObjTrait) Includes(other *ObjTrait) bool {
+	if t == other {
+		return true
+	}
+	for _, k := range t.traits {
+		if k.Includes(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ObjTrait) traitName() string {
+	return t.name
+}
+
+func (t *ObjTrait) traitMethods() map[string]*ObjClosure {
+	return t.methods
+}
+
+// MethodNames returns the names of the public methods, inherited ones
+// included, sorted.
+func (c *ObjClass) MethodNames() []string {
+	names := make([]string, 0, len(c.methods))
+	for name := range c.methods {
+		if !strings.HasPrefix(name, "#") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type ObjInstance struct {
+	class  *ObjClass
+	fields map[string]any
+	// '#name' fields, each class of the hierarchy has its own ones
+	private map[*ObjClass]map[string]any
+	// a frozen instance rejects any further Set
+	frozen bool
+}
+
+func (in *ObjInstance) ToString() string {
+	return in.class.name + " instance"
+}
+
+func (in *ObjInstance) ClassName() string {
+	return in.class.name
+}
+
+func (in *ObjInstance) MethodNames() []string {
+	return in.class.MethodNames()
+}
+
+func (in *ObjInstance) Field(name string) (any, bool) {
+	v, ok := in.fields[name]
+	return v, ok
+}
+
+// FieldNames returns the names of the fields, sorted.
+func (in *ObjInstance) FieldNames() []string {
+	names := make([]string, 0, len(in.fields))
+	for name := range in.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (in *ObjInstance) Set(name *Token, value any) {
+	if in.frozen {
+		Panic(name.line, fmt.Sprintf("Can't set property '%s' on a frozen instance.", name.lexeme))
+	}
+	in.fields[name.lexeme] = value
+}
+
+// GetPrivate returns the private field or method named name declared by
+// owner, private members of the superclasses aren't visible.
+func (in *ObjInstance) GetPrivate(owner *ObjClass, name *Token) any {
+	if v, ok := in.private[owner][name.lexeme]; ok {
+		return v
+	}
+	if method, ok := owner.methods[name.lexeme]; ok {
+		return &ObjBoundMethod{receiver: in, method: method}
+	}
+	Panic(name.line, fmt.Sprintf("Undefined private property '%s' of class %s.", name.lexeme, owner.name))
+	return nil
+}
+
+func (in *ObjInstance) SetPrivate(owner *ObjClass, name *Token, value any) {
+	if in.frozen {
+		Panic(name.line, fmt.Sprintf("Can't set property '%s' on a frozen instance.", name.lexeme))
+	}
+	if in.private == nil {
+		in.private = make(map[*ObjClass]map[string]any)
+	}
+	if in.private[owner] == nil {
+		in.private[owner] = make(map[string]any)
+	}
+	in.private[owner][name.lexeme] = value
+}
+
+func (in *ObjInstance) Freeze() {
+	in.frozen = true
+}
+
+func (in *ObjInstance) Has(name string) bool {
+	_, ok := in.fields[name]
+	_, method := in.class.methods[name]
+	return ok || method
+}
+
+func (in *ObjInstance) Get(name *Token) any {
+	if v, ok := in.fields[name.lexeme]; ok {
+		return v
+	}
+	if method, ok := in.class.methods[name.lexeme]; ok {
+		return &ObjBoundMethod{receiver: in, method: method}
+	}
+	Panic(name.line, fmt.Sprintf("Undefined property '%s'.", name.lexeme))
+	return nil
+}
+
+type ObjBoundMethod struct {
+	receiver *ObjInstance
+	method   *ObjClosure
+}
+
+func (b *ObjBoundMethod) ToString() string {
+	return b.method.ToString()
+}
+
+type CallFrame struct {
+	closure *ObjClosure
+	ip      int
+	// the index in the stack of the first slot of the frame
+	slots int
+}
+
+// VM runs the bytecode produced by Compile, the values are the ones of the
+// tree walker plus the Obj types.
+type VM struct {
+	// frames are reused from one call to the next, and stay where they are
+	// when more are added so run can hold the current one
+	frames       []*CallFrame
+	frameCount   int
+	stack        []any
+	sp           int
+	globals      map[string]any
+	globalConsts map[string]bool
+	// the globals defined with const, which can't be assigned
+	consts       map[string]bool
+	openUpvalues *ObjUpvalue
+	// runs the primitives
+	inter *Interpreter
+	// the VM resuming the generator this one runs, whose stack stays alive
+	// meanwhile
+	caller *VM
+	// set by a yield, which returns from run
+	yielded bool
+	// prints the stack and each instruction as they are run
+	trace bool
+	// shared with the VMs of the tasks, only the goroutine holding it runs
+	gil *sync.Mutex
+}
+
+// NewVM returns a VM whose globals are the primitives of inter.
+func NewVM(inter *Interpreter) *VM {
+	vm := &VM{
+		globals:      make(map[string]any),
+		globalConsts: make(map[string]bool),
+		consts:       make(map[string]bool),
+		stack:        make([]any, stackInit),
+		inter:        inter,
+		gil:          &sync.Mutex{},
+	}
+	inter.vm = vm
+	inter.globals.mu.RLock()
+	defer inter.globals.mu.RUnlock()
+	for name, value := range inter.globals.envs {
+		vm.globals[name] = value
+	}
+	// str has to know about the toString of the VM classes
+	vm.globals["str"] = NewPrimitive(1, func(args []any) any {
+		return vm.stringify(args[0])
+	})
+	return vm
+}
+
+// Interpret runs a compiled script and returns the value it returns, runtime
+// errors are reported like Interpreter.Execute does.
+func (vm *VM) Interpret(fn *ObjFunction) any {
+	ret, err := vm.InterpretContext(context.Background(), fn, Options{})
+	if err != nil {
+		printError(err)
+		hadError = true
+	}
+	return ret
+}
+
+// InterpretContext runs a compiled script within the limits of opts, like
+// Interpreter.ExecuteContext does. Each instruction is a step.
+func (vm *VM) InterpretContext(ctx context.Context, fn *ObjFunction, opts Options) (any, error) {
+	vm.gil.Lock()
+	defer vm.gil.Unlock()
+	vm.inter.gil = vm.gil
+	defer func() {
+		vm.inter.gil = nil
+	}()
+	return vm.inter.runWithin(ctx, opts, func() any {
+		closure := &ObjClosure{function: fn}
+		vm.push(closure)
+		vm.call(closure, 0)
+		return vm.run(0)
+	}, func() {
+		for vm.sp > 0 {
+			vm.pop()
+		}
+		vm.frameCount = 0
+		vm.openUpvalues = nil
+	})
+}
+
+func (vm *VM) push(v any) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, make([]any, len(vm.stack))...)
+	}
+	vm.stack[vm.sp] = v
+	vm.sp++
+}
+
+func (vm *VM) pop() any {
+	vm.sp--
+	v := vm.stack[vm.sp]
+	vm.stack[vm.sp] = nil
+	return v
+}
+
+func (vm *VM) peek(distance int) any {
+	return vm.stack[vm.sp-1-distance]
+}
+
+// line returns the source line of the instruction being run.
+func (vm *VM) line() int {
+	frame := vm.frames[vm.frameCount-1]
+	return frame.closure.function.chunk.lines[frame.ip-1]
+}
+
+func (vm *VM) runtimeError(msg string) {
+	Panic(vm.line(), msg)
+}
+
+func (vm *VM) call(closure *ObjClosure, argc int) {
+	fn := closure.function
+	if msg := fn.arityMessage(argc); msg != "" {
+		vm.runtimeError(msg)
+	}
+	// the same depth as the tree walker, the script isn't a call
+	if vm.frameCount > vm.inter.maxCallDepth {
+		vm.runtimeError("Stack overflow" + vm.stackTrace())
+	}
+	if vm.frameCount == len(vm.frames) {
+		vm.frames = append(vm.frames, &CallFrame{})
+	}
+	frame := vm.frames[vm.frameCount]
+	vm.frameCount++
+	frame.closure = closure
+	frame.ip = 0
+	frame.slots = vm.sp - argc - 1
+	// the parameters left out take their default value
+	for ; argc < fn.arity; argc++ {
+		vm.push(missingArgument{})
+	}
+	if fn.variadic {
+		rest := make([]any, argc-fn.arity)
+		copy(rest, vm.stack[vm.sp-len(rest):vm.sp])
+		for range rest {
+			vm.pop()
+		}
+		vm.inter.allocate(listSize + elementSize*len(rest))
+		vm.push(NewLoxList(rest))
+	}
+	if fn.generator {
+		vm.generator(frame)
+	}
+}
+
+// vmGenerator is the body of a generator of the VM, it runs on its own VM
+// sharing the globals of the one calling the generator function.
+type vmGenerator struct {
+	vm *VM
+}
+
+// generator moves the frame of a call to a generator function, along with
+// its arguments, to a new VM and leaves a generator in place of the callee.
+func (vm *VM) generator(frame *CallFrame) {
+	gen := &VM{
+		frames:       []*CallFrame{{closure: frame.closure}},
+		frameCount:   1,
+		stack:        make([]any, vm.sp-frame.slots, stackInit),
+		globals:      vm.globals,
+		globalConsts: vm.globalConsts,
+		consts:       vm.consts,
+		inter:        vm.inter,
+		trace:        vm.trace,
+		gil:          vm.gil,
+	}
+	gen.sp = copy(gen.stack, vm.stack[frame.slots:vm.sp])
+	gen.stack = gen.stack[:cap(gen.stack)]
+	vm.frameCount--
+	for vm.sp > frame.slots {
+		vm.pop()
+	}
+	vm.inter.allocate(closureSize)
+	vm.push(newGenerator(frame.closure.function.name, &vmGenerator{vm: gen}))
+}
+
+func (g *vmGenerator) resume(i *Interpreter) generatorResult {
+	g.vm.caller = i.vm
+	i.vm = g.vm
+	defer func() {
+		i.vm = g.vm.caller
+		g.vm.caller = nil
+	}()
+	value := g.vm.run(0)
+	if !g.vm.yielded {
+		return generatorResult{done: true}
+	}
+	g.vm.yielded = false
+	return generatorResult{value: value}
+}
+
+// abandon has nothing to do, a paused body is only data.
+func (g *vmGenerator) abandon() {}
+
+// bindKeywords replaces the argc arguments on top of the stack, the last of
+// which are named, with the arguments of the callee below them in the order
+// of its parameters, and returns their number.
+func (vm *VM) bindKeywords(argc int, names []*Token) int {
+	params, min := vm.signature(vm.peek(argc))
+	values := make([]any, argc)
+	copy(values, vm.stack[vm.sp-argc:vm.sp])
+	args := bindKeywordArgs(params, min, names, values, vm.line())
+	for range values {
+		vm.pop()
+	}
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	return len(args)
+}
+
+// signature returns the parameters keyword arguments can name of a callee,
+// the first min of them are required.
+func (vm *VM) signature(callee any) (params []*Token, min int) {
+	switch fn := callee.(type) {
+	case *ObjClosure:
+		return fn.function.params, fn.function.required
+	case *ObjBoundMethod:
+		return fn.method.function.params, fn.method.function.required
+	case *ObjClass:
+		if init, ok := fn.methods["init"]; ok {
+			return init.function.params, init.function.required
+		}
+	case Callable:
+		if kc, ok := fn.(keywordCallable); ok {
+			params = kc.Params()
+		}
+		min, _ = fn.Arity()
+		return params, min
+	}
+	return nil, 0
+}
+
+// stackTrace lists the innermost calls in progress, the frame of the script
+// isn't a call.
+func (vm *VM) stackTrace() string {
+	calls := make([]traceCall, 0, vm.frameCount)
+	for k := 1; k < vm.frameCount; k++ {
+		caller := vm.frames[k-1]
+		calls = append(calls, traceCall{
+			name: vm.frames[k].closure.ToString(),
+			line: caller.closure.function.chunk.lines[caller.ip-1],
+		})
+	}
+	return formatTrace(calls)
+}
+
+// callValue calls the value below the arguments. Closures get a new frame,
+// the other callables run right away and leave their result.
+func (vm *VM) callValue(callee any, argc int) {
+	switch fn := callee.(type) {
+	case *ObjClosure:
+		vm.call(fn, argc)
+		return
+	case *ObjBoundMethod:
+		vm.stack[vm.sp-argc-1] = fn.receiver
+		vm.call(fn.method, argc)
+		return
+	case *ObjClass:
+		vm.inter.allocate(instanceSize)
+		vm.stack[vm.sp-argc-1] = &ObjInstance{class: fn, fields: make(map[string]any)}
+		if init, ok := fn.methods["init"]; ok {
+			vm.call(init, argc)
+		} else if argc != 0 {
+			vm.runtimeError(fmt.Sprintf("Expected 0 arguments but got %v", argc))
+		}
+		return
+	case Callable:
+		if msg := arityMessage(fn, argc); msg != "" {
+			vm.runtimeError(msg)
+		}
+		args := make([]any, argc)
+		copy(args, vm.stack[vm.sp-argc:vm.sp])
+		vm.inter.line = vm.line()
+		result := fn.Call(vm.inter, args)
+		vm.sp -= argc + 1
+		vm.push(result)
+		return
+	}
+	vm.runtimeError(fmt.Sprintf("Expect callable but got %v", ToString(callee)))
+}
+
+// checkCall raises the error calling callee with argc arguments would.
+func (vm *VM) checkCall(callee any, argc int) {
+	msg := ""
+	switch fn := callee.(type) {
+	case *ObjClosure:
+		msg = fn.function.arityMessage(argc)
+	case *ObjBoundMethod:
+		msg = fn.method.function.arityMessage(argc)
+	case *ObjClass:
+		if init, ok := fn.methods["init"]; ok {
+			msg = init.function.arityMessage(argc)
+		} else if argc != 0 {
+			msg = fmt.Sprintf("Expected 0 arguments but got %v", argc)
+		}
+	case Callable:
+		msg = arityMessage(fn, argc)
+	default:
+		msg = fmt.Sprintf("Expect callable but got %v", ToString(callee))
+	}
+	if msg != "" {
+		vm.runtimeError(msg)
+	}
+}
+
+// spawn replaces the callee and its argc arguments on top of the stack with
+// a task running the call on a VM of its own, which shares the globals.
+func (vm *VM) spawn(argc int) {
+	callee := vm.peek(argc)
+	vm.checkCall(callee, argc)
+	values := make([]any, argc+1)
+	copy(values, vm.stack[vm.sp-argc-1:vm.sp])
+	for range values {
+		vm.pop()
+	}
+	vm.push(newTask(vm.inter, vm.stringify(callee), func(inter *Interpreter) any {
+		inter.gil.Lock()
+		defer inter.gil.Unlock()
+		task := &VM{
+			stack:        make([]any, stackInit),
+			globals:      vm.globals,
+			globalConsts: vm.globalConsts,
+			consts:       vm.consts,
+			inter:        inter,
+			trace:        vm.trace,
+			gil:          vm.gil,
+		}
+		inter.vm = task
+		for _, v := range values {
+			task.push(v)
+		}
+		task.callValue(callee, argc)
+		// primitives and generator functions return right away
+		if task.frameCount == 0 {
+			return task.pop()
+		}
+		return task.run(0)
+	}))
+}
+
+// invoke calls a method from Go and runs it to completion.
+func (vm *VM) invoke(receiver *ObjInstance, method *ObjClosure, args ...any) any {
+	base := vm.frameCount
+	vm.push(receiver)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	vm.call(method, len(args))
+	return vm.run(base)
+}
+
+// special returns the method implementing an operator or toString when v is
+// an instance whose class defines it.
+func (vm *VM) special(v any, name string) (*ObjInstance, *ObjClosure) {
+	if in, ok := v.(*ObjInstance); ok {
+		if method, ok := in.class.methods[name]; ok {
+			return in, method
+		}
+	}
+	return nil, nil
+}
+
+func (vm *VM) stringify(v any) string {
+	if in, method := vm.special(v, "toString"); method != nil {
+		ret := vm.invoke(in, method)
+		str, ok := ret.(string)
+		if !ok {
+			vm.runtimeError(fmt.Sprintf("toString must return a string but got %v", Repr(ret)))
+		}
+		return str
+	}
+	if list, ok := v.(*LoxList); ok {
+		return (&listPrinter{element: vm.repr}).print(list)
+	}
+	return ToString(v)
+}
+
+// repr is like stringify but quotes strings, it prints the elements of
+// collections.
+func (vm *VM) repr(v any) string {
+	switch v.(type) {
+	case string, *big.Int, *Decimal:
+		return Repr(v)
+	}
+	return vm.stringify(v)
+}
+
+func (vm *VM) captureUpvalue(location int) *ObjUpvalue {
+	var prev *ObjUpvalue
+	up := vm.openUpvalues
+	for up != nil && up.location > location {
+		prev = up
+		up = up.next
+	}
+	if up != nil && up.location == location {
+		return up
+	}
+	created := &ObjUpvalue{vm: vm, location: location, next: up}
+	if prev == nil {
+		vm.openUpvalues = created
+	} else {
+		prev.next = created
+	}
+	return created
+}
+
+// closeUpvalues moves the variables from the stack slot last onwards into
+// the upvalues capturing them.
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.location >= last {
+		up := vm.openUpvalues
+		up.closed = vm.stack[up.location]
+		up.isClosed = true
+		vm.openUpvalues = up.next
+	}
+}
+
+func (vm *VM) getUpvalue(up *ObjUpvalue) any {
+	if up.isClosed {
+		return up.closed
+	}
+	return up.vm.stack[up.location]
+}
+
+func (vm *VM) setUpvalue(up *ObjUpvalue, v any) {
+	if up.isClosed {
+		up.closed = v
+		return
+	}
+	up.vm.stack[up.location] = v
+}
+
+// matchCase is the pattern of an alternative of a match arm, exprs are its
+// expressions evaluated beforehand and names the variables of the arm.
+type matchCase struct {
+	pattern Pattern
+	exprs   []Expr
+	names   []string
+}
+
+// match pops the subject and the values of the expressions of mc, and
+// reports whether the subject matches. The variables of the arm, from the
+// stack slot base on, are set to what the pattern binds.
+func (vm *VM) match(mc *matchCase, base int) bool {
+	values := make(map[Expr]any, len(mc.exprs))
+	for k := len(mc.exprs) - 1; k >= 0; k-- {
+		values[mc.exprs[k]] = vm.pop()
+	}
+	subject := vm.pop()
+	m := &matcher{
+		evaluate: func(expr Expr) any {
+			if literal, ok := expr.(*Literal); ok {
+				return literal.value
+			}
+			return values[expr]
+		},
+		equal: vm.isEqual,
+	}
+	bindings := make(map[string]any)
+	if !m.match(mc.pattern, subject, bindings) {
+		return false
+	}
+	for k, name := range mc.names {
+		vm.stack[base+k] = bindings[name]
+	}
+	return true
+}
+
+// roots adds the values the VM holds to what m measures.
+func (vm *VM) roots(m *meter) {
+	for _, v := range vm.stack[:vm.sp] {
+		m.push(v)
+	}
+	for _, v := range vm.globals {
+		m.push(v)
+	}
+	for k := 0; k < vm.frameCount; k++ {
+		m.push(vm.frames[k].closure)
+	}
+	if vm.caller != nil {
+		vm.caller.roots(m)
+	}
+}
+
+func (vm *VM) isEqual(a, b any) bool {
+	if l, r, ok := floats(a, b); ok {
+		return l == r
+	}
+	if in, method := vm.special(a, "__eq__"); method != nil {
+		return vm.inter.isTruthy(vm.invoke(in, method, b))
+	}
+	if isNumber(a) && isNumber(b) {
+		return numbersEqual(a, b)
+	}
+	return a == b
+}
+
+// opTokens are the operators reported by the arithmetic helpers shared with
+// the tree walker.
+var opTokens = map[OpCode]*Token{
+	OP_ADD:           {typ: PLUS, lexeme: "+"},
+	OP_SUBTRACT:      {typ: MINUS, lexeme: "-"},
+	OP_MULTIPLY:      {typ: STAR, lexeme: "*"},
+	OP_DIVIDE:        {typ: SLASH, lexeme: "/"},
+	OP_GREATER:       {typ: GREATER, lexeme: ">"},
+	OP_GREATER_EQUAL: {typ: GREATER_EQUAL, lexeme: ">="},
+	OP_LESS:          {typ: LESS, lexeme: "<"},
+	OP_LESS_EQUAL:    {typ: LESS_EQUAL, lexeme: "<="},
+}
+
+// binaryOp is the slow path of the arithmetic and comparison opcodes, taken
+// unless both operands are floats.
+func (vm *VM) binaryOp(op OpCode, a, b any) any {
+	token := *opTokens[op]
+	token.line = vm.line()
+	if in, method := vm.special(a, operatorMethods[token.typ]); method != nil {
+		return vm.invoke(in, method, b)
+	}
+	if op == OP_ADD {
+		switch l := a.(type) {
+		case string:
+			s := l + vm.stringify(b)
+			vm.inter.allocate(stringSize + len(s))
+			return s
+		case *ObjInstance:
+			if r, ok := b.(string); ok {
+				s := vm.stringify(l) + r
+				vm.inter.allocate(stringSize + len(s))
+				return s
+			}
+		}
+	}
+	if ret, ok := compareMembers(&token, a, b); ok {
+		return ret
+	}
+	if isBigNumber(a) || isBigNumber(b) {
+		return vm.inter.allocateNumber(bigArithmetic(&token, a, b))
+	}
+	l, lok := a.(float64)
+	r, rok := b.(float64)
+	if !lok || !rok {
+		if op == OP_ADD && !lok {
+			vm.runtimeError(fmt.Sprintf("Expect string or float type but got %v", ToString(a)))
+		}
+		vm.runtimeError("should be float type")
+	}
+	switch op {
+	case OP_ADD:
+		return vm.inter.allocateNumber(promoteOverflow(&token, l, r, l+r))
+	case OP_SUBTRACT:
+		return vm.inter.allocateNumber(promoteOverflow(&token, l, r, l-r))
+	case OP_MULTIPLY:
+		return vm.inter.allocateNumber(promoteOverflow(&token, l, r, l*r))
+	case OP_DIVIDE:
+		return l / r
+	case OP_GREATER:
+		return l > r
+	case OP_GREATER_EQUAL:
+		return l >= r
+	case OP_LESS:
+		return l < r
+	}
+	return l <= r
+}
+
+func (vm *VM) instanceOf(value, class any) bool {
+	switch c := class.(type) {
+	case *ObjClass:
+		in, ok := value.(*ObjInstance)
+		return ok && in.class.IsSubclassOf(c)
+	case *ObjTrait:
+		in, ok := value.(*ObjInstance)
+		return ok && in.class.Includes(c)
+	}
+	return vm.inter.isInstance(value, class, &Token{line: vm.line()})
+}
+
+// include merges the methods of the traits on top of the stack into the
+// class or the trait below them, which declaration decl is. The methods of
+// the traits override the inherited ones.
+func (vm *VM) include(decl Stmt) {
+	var name *Token
+	var names []*Variable
+	var own []*Function
+	switch d := decl.(type) {
+	case *Class:
+		name, names, own = d.name, d.traits, d.methods
+	case *Trait:
+		name, names, own = d.name, d.traits, d.methods
+	}
+	traits := make([]*ObjTrait, len(names))
+	for k := len(names) - 1; k >= 0; k-- {
+		trait, ok := vm.pop().(*ObjTrait)
+		if !ok {
+			Panic(names[k].name.line, fmt.Sprintf("'%s' is not a trait.", names[k].name.lexeme))
+		}
+		traits[k] = trait
+	}
+	methods := mergeTraits[*ObjTrait, *ObjClosure](name, traits, own)
+	var into map[string]*ObjClosure
+	switch t := vm.peek(0).(type) {
+	case *ObjClass:
+		t.traits = traits
+		into = t.methods
+	case *ObjTrait:
+		t.traits = traits
+		into = t.methods
+	}
+	for n, method := range methods {
+		into[n] = method
+	}
+}
+
+// run executes instructions until the frame count drops back to base, the
+// value returned by that frame is returned.
+func (vm *VM) run(base int) any {
+	frame := vm.frames[vm.frameCount-1]
+	code := frame.closure.function.chunk.code
+	constants := frame.closure.function.chunk.constants
+	for {
+		if vm.trace {
+			vm.traceStack()
+			DisassembleInstruction(&frame.closure.function.chunk, frame.ip)
+		}
+		vm.inter.step()
+		op := OpCode(code[frame.ip])
+		frame.ip++
+		switch op {
+		case OP_CONSTANT:
+			vm.push(constants[int(code[frame.ip])<<8|int(code[frame.ip+1])])
+			frame.ip += 2
+		case OP_NIL:
+			vm.push(nil)
+		case OP_TRUE:
+			vm.push(true)
+		case OP_FALSE:
+			vm.push(false)
+		case OP_POP:
+			vm.pop()
+		case OP_GET_LOCAL:
+			vm.push(vm.stack[frame.slots+int(code[frame.ip])])
+			frame.ip++
+		case OP_SET_LOCAL:
+			vm.stack[frame.slots+int(code[frame.ip])] = vm.peek(0)
+			frame.ip++
+		case OP_GET_GLOBAL:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			v, ok := vm.globals[name]
+			if !ok {
+				vm.runtimeError(fmt.Sprintf("Undefined variable '%s'.", name))
+			}
+			vm.push(v)
+		case OP_DEFINE_GLOBAL:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			vm.globals[name] = vm.pop()
+			delete(vm.consts, name)
+		case OP_DEFINE_CONST:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			vm.globals[name] = vm.pop()
+			vm.consts[name] = true
+		case OP_SET_GLOBAL:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			if _, ok := vm.globals[name]; !ok {
+				vm.runtimeError(fmt.Sprintf("Undefined variable '%s'.", name))
+			}
+			if vm.consts[name] {
+				vm.runtimeError(fmt.Sprintf("Can't assign to constant '%s'.", name))
+			}
+			vm.globals[name] = vm.peek(0)
+		case OP_GET_UPVALUE:
+			vm.push(vm.getUpvalue(frame.closure.upvalues[code[frame.ip]]))
+			frame.ip++
+		case OP_SET_UPVALUE:
+			vm.setUpvalue(frame.closure.upvalues[code[frame.ip]], vm.peek(0))
+			frame.ip++
+		case OP_GET_PROPERTY:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			o, ok := vm.peek(0).(Object)
+			if !ok {
+				vm.runtimeError("Only instances have properties")
+			}
+			vm.stack[vm.sp-1] = o.Get(&Token{typ: IDENTIFIER, lexeme: name, line: vm.line()})
+		case OP_SET_PROPERTY:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			in, ok := vm.peek(1).(*ObjInstance)
+			if !ok {
+				vm.runtimeError("Only instances have fields")
+			}
+			if _, ok := in.fields[name]; !ok {
+				vm.inter.allocate(fieldSize)
+			}
+			in.Set(&Token{typ: IDENTIFIER, lexeme: name, line: vm.line()}, vm.peek(0))
+			value := vm.pop()
+			vm.stack[vm.sp-1] = value
+		case OP_GET_PRIVATE:
+			name := &Token{typ: PRIVATE_IDENTIFIER, lexeme: constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string), line: vm.line()}
+			frame.ip += 2
+			owner := vm.pop().(*ObjClass)
+			vm.stack[vm.sp-1] = vm.peek(0).(*ObjInstance).GetPrivate(owner, name)
+		case OP_SET_PRIVATE:
+			name := &Token{typ: PRIVATE_IDENTIFIER, lexeme: constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string), line: vm.line()}
+			frame.ip += 2
+			value := vm.pop()
+			owner := vm.pop().(*ObjClass)
+			in := vm.peek(0).(*ObjInstance)
+			if _, ok := in.private[owner][name.lexeme]; !ok {
+				vm.inter.allocate(fieldSize)
+			}
+			in.SetPrivate(owner, name, value)
+			vm.stack[vm.sp-1] = value
+		case OP_GET_SUPER:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			superclass := vm.pop().(*ObjClass)
+			method, ok := superclass.methods[name]
+			if !ok {
+				vm.runtimeError(fmt.Sprintf("Undefined property '%s'.", name))
+			}
+			vm.stack[vm.sp-1] = &ObjBoundMethod{receiver: vm.peek(0).(*ObjInstance), method: method}
+		case OP_EQUAL:
+			b := vm.pop()
+			vm.stack[vm.sp-1] = vm.isEqual(vm.peek(0), b)
+		case OP_ADD, OP_SUBTRACT, OP_MULTIPLY, OP_DIVIDE, OP_GREATER, OP_GREATER_EQUAL, OP_LESS, OP_LESS_EQUAL:
+			b := vm.pop()
+			a := vm.peek(0)
+			l, lok := a.(float64)
+			r, rok := b.(float64)
+			if !lok || !rok {
+				vm.stack[vm.sp-1] = vm.binaryOp(op, a, b)
+				break
+			}
+			var result any
+			switch op {
+			case OP_ADD:
+				result = l + r
+			case OP_SUBTRACT:
+				result = l - r
+			case OP_MULTIPLY:
+				result = l * r
+			case OP_DIVIDE:
+				result = l / r
+			case OP_GREATER:
+				result = l > r
+			case OP_GREATER_EQUAL:
+				result = l >= r
+			case OP_LESS:
+				result = l < r
+			case OP_LESS_EQUAL:
+				result = l <= r
+			}
+			// integer results too large for a float64 become big integers
+			if f, ok := result.(float64); ok && math.Abs(f) >= maxSafeInteger && op != OP_DIVIDE {
+				result = vm.binaryOp(op, a, b)
+			}
+			vm.stack[vm.sp-1] = result
+		case OP_INSTANCEOF:
+			b := vm.pop()
+			vm.stack[vm.sp-1] = vm.instanceOf(vm.peek(0), b)
+		case OP_NOT:
+			vm.stack[vm.sp-1] = !vm.inter.isTruthy(vm.peek(0))
+		case OP_NEGATE:
+			switch n := vm.peek(0).(type) {
+			case float64:
+				vm.stack[vm.sp-1] = -n
+			case *big.Int:
+				vm.stack[vm.sp-1] = vm.inter.allocateNumber(new(big.Int).Neg(n))
+			case *Decimal:
+				vm.stack[vm.sp-1] = vm.inter.allocateNumber(n.Neg())
+			default:
+				if in, method := vm.special(n, "__neg__"); method != nil {
+					vm.stack[vm.sp-1] = vm.invoke(in, method)
+					break
+				}
+				vm.runtimeError("should be float type")
+			}
+		case OP_PRINT:
+			fmt.Println(vm.stringify(vm.pop()))
+		case OP_JUMP:
+			frame.ip += 2 + (int(code[frame.ip])<<8 | int(code[frame.ip+1]))
+		case OP_JUMP_IF_FALSE:
+			offset := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			if !vm.inter.isTruthy(vm.peek(0)) {
+				frame.ip += offset
+			}
+		case OP_JUMP_IF_NIL:
+			offset := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			if vm.peek(0) == nil {
+				frame.ip += offset
+			}
+		case OP_LOOP:
+			offset := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2 - offset
+		case OP_SPAWN, OP_SPAWN_KEYWORDS:
+			argc := int(code[frame.ip])
+			frame.ip++
+			if op == OP_SPAWN_KEYWORDS {
+				names := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].([]*Token)
+				frame.ip += 2
+				argc = vm.bindKeywords(argc, names)
+			}
+			vm.spawn(argc)
+		case OP_CALL, OP_CALL_KEYWORDS, OP_TAIL_CALL, OP_TAIL_CALL_KEYWORDS:
+			argc := int(code[frame.ip])
+			frame.ip++
+			if op == OP_CALL_KEYWORDS || op == OP_TAIL_CALL_KEYWORDS {
+				names := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].([]*Token)
+				frame.ip += 2
+				argc = vm.bindKeywords(argc, names)
+			}
+			if op == OP_TAIL_CALL || op == OP_TAIL_CALL_KEYWORDS {
+				// the callee and its arguments take the place of the frame
+				// returning their result
+				vm.closeUpvalues(frame.slots)
+				vm.frameCount--
+				copy(vm.stack[frame.slots:], vm.stack[vm.sp-argc-1:vm.sp])
+				for vm.sp > frame.slots+argc+1 {
+					vm.pop()
+				}
+			}
+			vm.callValue(vm.peek(argc), argc)
+			// a primitive tail called by the outermost frame returns
+			if vm.frameCount == base {
+				return vm.pop()
+			}
+			frame = vm.frames[vm.frameCount-1]
+			code = frame.closure.function.chunk.code
+			constants = frame.closure.function.chunk.constants
+		case OP_JUMP_IF_PASSED:
+			slot := int(code[frame.ip])
+			offset := int(code[frame.ip+1])<<8 | int(code[frame.ip+2])
+			frame.ip += 3
+			if _, ok := vm.stack[frame.slots+slot].(missingArgument); !ok {
+				frame.ip += offset
+			}
+		case OP_CLOSURE:
+			fn := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(*ObjFunction)
+			frame.ip += 2
+			vm.inter.allocate(closureSize)
+			closure := &ObjClosure{function: fn, upvalues: make([]*ObjUpvalue, fn.upvalueCount)}
+			for k := range closure.upvalues {
+				isLocal, index := code[frame.ip], int(code[frame.ip+1])
+				frame.ip += 2
+				if isLocal == 1 {
+					closure.upvalues[k] = vm.captureUpvalue(frame.slots + index)
+				} else {
+					closure.upvalues[k] = frame.closure.upvalues[index]
+				}
+			}
+			vm.push(closure)
+		case OP_CLOSE_UPVALUE:
+			vm.closeUpvalues(vm.sp - 1)
+			vm.pop()
+		case OP_RETURN:
+			result := vm.pop()
+			vm.closeUpvalues(frame.slots)
+			vm.frameCount--
+			for vm.sp > frame.slots {
+				vm.pop()
+			}
+			if vm.frameCount == base {
+				return result
+			}
+			vm.push(result)
+			frame = vm.frames[vm.frameCount-1]
+			code = frame.closure.function.chunk.code
+			constants = frame.closure.function.chunk.constants
+		case OP_YIELD:
+			vm.yielded = true
+			return vm.pop()
+		case OP_CLASS:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			vm.push(&ObjClass{name: name, methods: make(map[string]*ObjClosure)})
+		case OP_INHERIT:
+			superclass, ok := vm.peek(1).(*ObjClass)
+			if !ok {
+				vm.runtimeError("Superclass must be a class.")
+			}
+			subclass := vm.peek(0).(*ObjClass)
+			for name, method := range superclass.methods {
+				// private methods stay with the class declaring them
+				if !strings.HasPrefix(name, "#") {
+					subclass.methods[name] = method
+				}
+			}
+			subclass.superclass = superclass
+			vm.pop()
+		case OP_METHOD:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			switch class := vm.peek(1).(type) {
+			case *ObjClass:
+				class.methods[name] = vm.pop().(*ObjClosure)
+			case *ObjTrait:
+				class.methods[name] = vm.pop().(*ObjClosure)
+			}
+		case OP_TRAIT:
+			name := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(string)
+			frame.ip += 2
+			vm.push(&ObjTrait{name: name, methods: make(map[string]*ObjClosure)})
+		case OP_WITH:
+			decl := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(Stmt)
+			frame.ip += 2
+			vm.include(decl)
+		case OP_ENUM:
+			decl := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(*Enum)
+			frame.ip += 2
+			vm.push(NewLoxEnum(decl.name.lexeme, decl.members))
+		case OP_MATCH:
+			mc := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(*matchCase)
+			base := frame.slots + int(code[frame.ip+2])
+			frame.ip += 3
+			vm.push(vm.match(mc, base))
+		case OP_DESTRUCTURE:
+			pattern := constants[int(code[frame.ip])<<8|int(code[frame.ip+1])].(Pattern)
+			frame.ip += 2
+			destructure(pattern, vm.pop(), func(name *Token, value any) {
+				vm.push(value)
+			})
+		default:
+			vm.runtimeError(fmt.Sprintf("Unknown opcode %v.", op))
+		}
+	}
+}